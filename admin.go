@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pendingJobInfo describes a pending job to the admin api
+type pendingJobInfo struct {
+	// Key is the job, followed by the change for jobs of merge requests,
+	// pull requests and patch sets
+	Key    string     `json:"key"`
+	Job    string     `json:"job"`
+	FireAt time.Time  `json:"fire_at"`
+	Held   bool       `json:"held"`
+	Params url.Values `json:"params,omitempty"`
+	Events []string   `json:"events"`
+}
+
+// adminStatus is the state of the triggering reported by the admin api
+type adminStatus struct {
+	Paused bool             `json:"paused"`
+	Jobs   []pendingJobInfo `json:"jobs"`
+}
+
+// pendingJobs returns all pending jobs ordered by their fire time
+func (s *server) pendingJobs() adminStatus {
+	s.timeKeeperLock.Lock()
+	defer s.timeKeeperLock.Unlock()
+
+	status := adminStatus{Paused: s.paused, Jobs: []pendingJobInfo{}}
+	for key, pj := range s.timeKeeper {
+		status.Jobs = append(status.Jobs, pendingJobInfo{
+			Key:    key,
+			Job:    pj.job,
+			FireAt: pj.fireAt,
+			Held:   pj.held,
+			Params: pj.params,
+			Events: append([]string{}, pj.events...),
+		})
+	}
+
+	sort.Slice(status.Jobs, func(i, j int) bool {
+		if status.Jobs[i].FireAt.Equal(status.Jobs[j].FireAt) {
+			return status.Jobs[i].Key < status.Jobs[j].Key
+		}
+
+		return status.Jobs[i].FireAt.Before(status.Jobs[j].FireAt)
+	})
+
+	return status
+}
+
+// takeJobs stops and removes the timers of the given jobs, or of all jobs
+// if none are given, and returns the removed jobs by key. A job is given by
+// its key or its name, the name takes the job of all changes.
+func (s *server) takeJobs(jobs ...string) map[string]*pendingJob {
+	s.timeKeeperLock.Lock()
+	defer s.timeKeeperLock.Unlock()
+
+	selected := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		selected[job] = true
+	}
+
+	taken := make(map[string]*pendingJob)
+	for key, pj := range s.timeKeeper {
+		if len(jobs) > 0 && !selected[key] && !selected[pj.job] {
+			continue
+		}
+
+		pj.timer.Stop()
+		delete(s.timeKeeper, key)
+		taken[key] = pj
+	}
+
+	return taken
+}
+
+// cancelJobs drops the given pending jobs, or all if none are given, and
+// returns their keys
+func (s *server) cancelJobs(jobs ...string) []string {
+	cancelled := []string{}
+	for key, pj := range s.takeJobs(jobs...) {
+		loggerFrom(pj.context()).info("cancelled job", "key", key)
+		cancelled = append(cancelled, key)
+	}
+
+	sort.Strings(cancelled)
+
+	return cancelled
+}
+
+// cancelChange drops the pending jobs of the change given by params in the
+// project of repo and returns their keys
+func (s *server) cancelChange(repo string, params url.Values) []string {
+	suffix := pendingKey("", repo, params)
+	if suffix == "" {
+		return []string{}
+	}
+
+	keys := []string{}
+	s.timeKeeperLock.Lock()
+	for key := range s.timeKeeper {
+		if strings.HasSuffix(key, suffix) {
+			keys = append(keys, key)
+		}
+	}
+	s.timeKeeperLock.Unlock()
+
+	if len(keys) == 0 {
+		return keys
+	}
+
+	return s.cancelJobs(keys...)
+}
+
+// fireJob triggers a pending job right away, even if triggering is paused.
+// It reports whether the job was pending and whether jenkins was reached
+// for all its changes.
+func (s *server) fireJob(job string) (bool, bool) {
+	taken := s.takeJobs(job)
+	if len(taken) == 0 {
+		return false, false
+	}
+
+	triggered := true
+	for _, pj := range taken {
+		ctx := pj.context()
+		loggerFrom(ctx).info("firing job")
+
+		if !s.triggerJob(ctx, pj.job, pj.params) {
+			triggered = false
+		}
+	}
+
+	return true, triggered
+}
+
+// setPaused pauses or resumes triggering. Jobs held during the pause are
+// released on resume, one per release interval. If a release is still
+// running, it continues with the jobs held since.
+func (s *server) setPaused(paused bool) {
+	s.timeKeeperLock.Lock()
+	wasPaused := s.paused
+	s.paused = paused
+	release := wasPaused && !paused && !s.releasing
+	if release {
+		s.releasing = true
+	}
+	s.timeKeeperLock.Unlock()
+
+	if paused {
+		defaultLogger.info("triggering paused")
+
+		return
+	}
+
+	defaultLogger.info("triggering resumed")
+
+	if release {
+		go s.releaseHeldJobs()
+	}
+}
+
+// nextHeldJob removes and returns the held job which was due first. It
+// returns false and ends the release if there is none or triggering was
+// paused again.
+func (s *server) nextHeldJob() (*pendingJob, bool) {
+	s.timeKeeperLock.Lock()
+	defer s.timeKeeperLock.Unlock()
+
+	if s.paused {
+		s.releasing = false
+
+		return nil, false
+	}
+
+	next := ""
+	for key, pj := range s.timeKeeper {
+		if !pj.held {
+			continue
+		}
+		if next == "" || pj.fireAt.Before(s.timeKeeper[next].fireAt) {
+			next = key
+		}
+	}
+
+	if next == "" {
+		s.releasing = false
+
+		return nil, false
+	}
+
+	pj := s.timeKeeper[next]
+	delete(s.timeKeeper, next)
+
+	return pj, true
+}
+
+// releaseHeldJobs triggers the held jobs in the order they were due, with
+// the release interval in between
+func (s *server) releaseHeldJobs() {
+	for {
+		pj, ok := s.nextHeldJob()
+		if !ok {
+			return
+		}
+
+		ctx := pj.context()
+		loggerFrom(ctx).info("releasing held job")
+		s.triggerJob(ctx, pj.job, pj.params)
+
+		time.Sleep(s.param.proxy.ReleaseInterval)
+	}
+}
+
+// adminPaths are the paths served by the admin api
+var adminPaths = map[string]bool{
+	"/admin/jobs":              true,
+	"/admin/jobs/fire":         true,
+	"/admin/pause":             true,
+	"/admin/resume":            true,
+	"/admin/builds":            true,
+	"/admin/deliveries":        true,
+	"/admin/deliveries/replay": true,
+}
+
+// checkAdminAuth reports whether the request carries the admin token as
+// bearer token
+func (s *server) checkAdminAuth(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.param.proxy.AdminToken)) == 1
+}
+
+// handleAdmin serves the admin api to inspect and control pending jobs.
+// The api is disabled without an admin token.
+func (s *server) handleAdmin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.param.proxy.AdminToken == "" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		if !s.checkAdminAuth(r) {
+			loggerFrom(r.Context()).warn("unauthorized admin request")
+
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		job := r.URL.Query().Get("job")
+
+		switch {
+		case r.URL.Path == "/admin/jobs" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, s.pendingJobs())
+		case r.URL.Path == "/admin/jobs" && r.Method == http.MethodDelete:
+			var cancelled []string
+			if job != "" {
+				cancelled = s.cancelJobs(job)
+			} else {
+				cancelled = s.cancelJobs()
+			}
+
+			if job != "" && len(cancelled) == 0 {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, map[string][]string{"cancelled": cancelled})
+		case r.URL.Path == "/admin/jobs/fire" && r.Method == http.MethodPost:
+			found, triggered := s.fireJob(job)
+			if !found {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, map[string]interface{}{"job": job, "triggered": triggered})
+		case r.URL.Path == "/admin/pause" && r.Method == http.MethodPost:
+			s.setPaused(true)
+			writeJSON(w, http.StatusOK, s.pendingJobs())
+		case r.URL.Path == "/admin/resume" && r.Method == http.MethodPost:
+			s.setPaused(false)
+			writeJSON(w, http.StatusOK, s.pendingJobs())
+		case r.URL.Path == "/admin/builds" && r.Method == http.MethodGet:
+			builds := []trackedBuild{}
+			if s.builds != nil {
+				builds = s.builds.list()
+			}
+
+			writeJSON(w, http.StatusOK, builds)
+		case r.URL.Path == "/admin/deliveries" && r.Method == http.MethodGet:
+			if s.deliveries == nil {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, s.deliveries.list())
+		case r.URL.Path == "/admin/deliveries/replay" && r.Method == http.MethodPost:
+			if s.deliveries == nil {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			e, err := s.replayDelivery(r.URL.Query().Get("id"))
+			if err == errUnknownDelivery {
+				http.NotFound(w, r)
+
+				return
+			} else if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+
+				return
+			}
+
+			e.Header = redactHeader(e.Header)
+			writeJSON(w, http.StatusOK, e)
+		case adminPaths[r.URL.Path]:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
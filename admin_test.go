@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newJenkinsStub(triggered chan string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		triggered <- r.URL.Path
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
+
+func adminRequest(s *server, method, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	s.handleAdmin().ServeHTTP(w, r)
+
+	return w
+}
+
+func Test_server_handleAdminAuth(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		auth  string
+		want  int
+	}{
+		{"disabled", "", "Bearer ", http.StatusNotFound},
+		{"missing", "secret", "", http.StatusUnauthorized},
+		{"wrong", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"valid", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{AdminToken: tt.token}},
+			}
+			r := httptest.NewRequest("GET", "/admin/jobs", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			s.handleAdmin().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_handleAdminJobs(t *testing.T) {
+	triggered := make(chan string, 10)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL, Token: "token"},
+			proxy:   proxy{QuietPeriod: 60, AdminToken: "secret"},
+		},
+	}
+	s.createTimer(context.Background(), "repo", "job1", nil, "repo master")
+	s.createTimer(context.Background(), "repo", "job1", nil, "repo feature")
+	s.createTimer(context.Background(), "repo", "job2", nil, "repo master")
+	s.createTimer(context.Background(), "repo", "job3", nil, "repo master")
+	defer s.cancelJobs()
+
+	w := adminRequest(s, "GET", "/admin/jobs")
+	var status adminStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Jobs) != 3 || status.Jobs[0].Job != "job1" || len(status.Jobs[0].Events) != 2 {
+		t.Errorf("list returned %+v", status)
+	}
+
+	if w := adminRequest(s, "DELETE", "/admin/jobs?job=job2"); w.Code != http.StatusOK {
+		t.Errorf("cancel returned %v", w.Code)
+	}
+	if w := adminRequest(s, "DELETE", "/admin/jobs?job=job2"); w.Code != http.StatusNotFound {
+		t.Errorf("cancel of unknown job returned %v", w.Code)
+	}
+
+	if w := adminRequest(s, "POST", "/admin/jobs/fire?job=job3"); w.Code != http.StatusOK {
+		t.Errorf("fire returned %v", w.Code)
+	}
+	if path := <-triggered; path != "/job/job3/build" {
+		t.Errorf("fire triggered %s", path)
+	}
+
+	if s.isPending("job2") || s.isPending("job3") || !s.isPending("job1") {
+		t.Errorf("unexpected pending jobs: %+v", s.pendingJobs())
+	}
+
+	if w := adminRequest(s, "GET", "/admin/jobs/fire?job=job1"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("fire with GET returned %v", w.Code)
+	}
+}
+
+func Test_server_handleAdminPause(t *testing.T) {
+	triggered := make(chan string, 10)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL, Token: "token"},
+			proxy:   proxy{QuietPeriod: 0, AdminToken: "secret"},
+		},
+	}
+
+	adminRequest(s, "POST", "/admin/pause")
+	s.createTimer(context.Background(), "repo", "job", nil, "repo master")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status := s.pendingJobs()
+		if len(status.Jobs) == 1 && status.Jobs[0].Held {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job not held: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case path := <-triggered:
+		t.Fatalf("paused proxy triggered %s", path)
+	default:
+	}
+
+	adminRequest(s, "POST", "/admin/resume")
+
+	select {
+	case path := <-triggered:
+		if path != "/job/job/build" {
+			t.Errorf("resume triggered %s", path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("resume didn't trigger held job")
+	}
+
+	if s.isPending("job") {
+		t.Error("held job still pending after resume")
+	}
+}
+
+func Test_server_releaseHeldJobs(t *testing.T) {
+	triggered := make(chan string, 10)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL, Token: "token"},
+			proxy:   proxy{QuietPeriod: 0, ReleaseInterval: 100 * time.Millisecond},
+		},
+	}
+
+	waitHeld := func(n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			held := 0
+			for _, job := range s.pendingJobs().Jobs {
+				if job.Held {
+					held++
+				}
+			}
+			if held == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("jobs not held: %+v", s.pendingJobs())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	s.setPaused(true)
+	s.createTimer(context.Background(), "repo", "first", nil, "repo master")
+	waitHeld(1)
+	s.createTimer(context.Background(), "repo", "second", nil, "repo master")
+	waitHeld(2)
+	s.createTimer(context.Background(), "repo", "second", nil, "repo feature")
+	waitHeld(2)
+
+	start := time.Now()
+	s.setPaused(false)
+
+	for _, want := range []string{"/job/first/build", "/job/second/build"} {
+		select {
+		case path := <-triggered:
+			if path != want {
+				t.Errorf("released %s, want %s", path, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("held job not released")
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("held jobs released within %v", elapsed)
+	}
+
+	select {
+	case path := <-triggered:
+		t.Errorf("deduplicated job triggered again: %s", path)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func Test_server_releaseHeldJobsOnce(t *testing.T) {
+	triggered := make(chan string, 10)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL, Token: "token"},
+			proxy:   proxy{QuietPeriod: 0, ReleaseInterval: 100 * time.Millisecond},
+		},
+	}
+
+	s.setPaused(true)
+	for _, job := range []string{"first", "second", "third"} {
+		s.createTimer(context.Background(), "repo", job, nil, "repo master")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		held := 0
+		for _, job := range s.pendingJobs().Jobs {
+			if job.Held {
+				held++
+			}
+		}
+		if held == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("jobs not held: %+v", s.pendingJobs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// resuming again within the release interval must not start a second
+	// release running in parallel
+	start := time.Now()
+	s.setPaused(false)
+	s.setPaused(true)
+	s.setPaused(false)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-triggered:
+		case <-time.After(2 * time.Second):
+			t.Fatal("held job not released")
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("three held jobs released within %v, want one per release interval", elapsed)
+	}
+}
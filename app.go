@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,21 +17,52 @@ const (
 	defQp    = 10   // default quiet period (in sec)
 	defPort  = 8080 // default http port
 	defInt   = 5    // default interfall of mapping refresh (in min)
+
+	defPollInt    = 5 * time.Minute // default interval to poll repos
+	defReleaseInt = time.Second     // default interval between jobs released after a pause
+	defAuditSize  = 100             // default size of the audit file before it is rotated (in MB)
+	defDeliveries = 100             // default number of deliveries kept for the admin api
+
+	defJenkinsPoll   = 2 * time.Second  // default interval to poll jenkins queue items and builds
+	defQueueTimeout  = 10 * time.Minute // default time for a queue item to start a build
+	defResultTimeout = 2 * time.Hour    // default time for a build to finish
+	defProbeInt      = 30 * time.Second // default interval to probe jenkins for the readiness check
+
+	defShutdownTimeout = 30 * time.Second // default time to wait for running requests on shutdown
+
+	defMRActions = "open,reopen,update"                           // default merge request actions to trigger on
+	defPRActions = "opened,synchronize,reopened,ready_for_review" // default pull request actions to trigger on
 )
 
 type mapping map[string][]string
 
 type server struct {
 	mapping                mapping
+	polls                  map[string]time.Duration // repos of the poll section with their interval
 	mappingHash            string
 	mappingSource          mappingHandler
 	mappingRefreshInterval time.Duration
-	timeKeeper             map[string]*time.Timer
+	timeKeeper             map[string]*pendingJob
+	timeKeeperLock         *sync.Mutex // guards the time keeper, paused and releasing
+	mirror                 *gitMirror
+	poller                 *poller
+	audit                  *auditLog
+	deliveries             *deliveryLog
+	builds                 *buildTracker
+	status                 *statusReporter
+	health                 *health
+	clients                *httpClients
+	genericHooks           map[string]genericHook
+	paused                 bool
+	releasing              bool // set while held jobs are released after a pause
 	param                  parameters
 }
 
 type parameters struct {
 	jenkins jenkins
+	gitlab  gitlab
+	gerrit  gerrit
+	azure   azure
 	proxy   proxy
 }
 
@@ -38,21 +71,86 @@ type jenkins struct {
 	User  string
 	Token string
 	Multi string
+
+	FollowQueue   bool
+	FollowResult  bool
+	PollInterval  time.Duration
+	QueueTimeout  time.Duration
+	ResultTimeout time.Duration
+
+	ProbeInterval time.Duration
+	RequireReady  bool
+
+	Insecure   bool
+	ClientCert string
+	ClientKey  string
+}
+
+type gitlab struct {
+	URL   string
+	Token string
+}
+
+type gerrit struct {
+	URL string
+}
+
+type azure struct {
+	User     string
+	Password string
 }
 
 type mappingSource struct {
-	path string
-	hash string
+	path   string
+	hash   string
+	client *http.Client
 }
 
 type proxy struct {
-	QuietPeriod  int
-	FileMatching bool
-	SemanticRepo string
-	port         int
+	QuietPeriod     int
+	FileMatching    bool
+	SemanticRepo    string
+	GitCache        string
+	GenericHooks    string
+	CloudEventTypes []string
+	SkipMarkers     []string
+	SkipAuthors     []string
+	PollState       string
+	PollInterval    time.Duration
+	MRActions       []string
+	PRActions       []string
+	PRSkipDraft     bool
+	AdminToken      string
+	LogFormat       string
+	LogLevel        string
+	AuditFile       string
+	AuditMaxSize    int
+	AuditDaily      bool
+	Deliveries      int
+	DeliveriesFile  string
+	StatusHosts     string
+	StatusName      string
+	ReleaseInterval time.Duration
+	PendingPolicy   string
+	PendingFile     string
+	ShutdownTimeout time.Duration
+	TLSCert         string
+	TLSKey          string
+	TLSClientCA     string
+	CABundle        string
+	MappingInsecure bool
+	port            int
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == hookCommand {
+		if err := runHook(os.Args[1:], os.Stdin); err != nil {
+			log.Fatalf("%s\n", err)
+		}
+
+		return
+	}
+
 	if err := run(os.Args); err != nil {
 		log.Fatalf("%s\n", err)
 		os.Exit(exitFail)
@@ -62,15 +160,22 @@ func main() {
 // newServer returns a new trigger proxy server
 func newServer(args []string) (server, error) {
 	s := server{
-		mapping:     make(mapping),
-		mappingHash: "",
-		timeKeeper:  make(map[string]*time.Timer),
+		mapping:        make(mapping),
+		mappingHash:    "",
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		builds:         newBuildTracker(),
+		health:         newHealth(),
 	}
 
 	if err := s.parseFlags(args); err != nil {
 		return s, err
 	}
 
+	if err := configureLogger(s.param.proxy.LogFormat, s.param.proxy.LogLevel); err != nil {
+		return s, err
+	}
+
 	log.Println("checking configuration")
 
 	if s.param.jenkins.URL == "" {
@@ -95,7 +200,20 @@ func newServer(args []string) (server, error) {
 		s.param.jenkins.URL = s.param.jenkins.URL + "/job/" + s.param.jenkins.Multi
 	}
 
+	if s.param.azure.User == "" && s.param.azure.Password == "" {
+		log.Println("no azure devops credentials defined")
+	}
+
+	if s.param.gitlab.Token == "" {
+		log.Println("no gitlab token defined")
+	}
+
 	log.Printf("quiet period: %d\n", s.param.proxy.QuietPeriod)
+	if len(s.param.proxy.SkipAuthors) > 0 {
+		log.Printf("ignored authors: %s\n", strings.Join(s.param.proxy.SkipAuthors, ","))
+	}
+	log.Printf("merge request actions: %s\n", strings.Join(s.param.proxy.MRActions, ","))
+	log.Printf("pull request actions: %s (skip drafts: %t)\n", strings.Join(s.param.proxy.PRActions, ","), s.param.proxy.PRSkipDraft)
 
 	// log.Printf("mapping source: %s\n", s.mappingSource)
 
@@ -105,6 +223,78 @@ func newServer(args []string) (server, error) {
 		s.param.proxy.FileMatching = true
 	}
 
+	if !validPendingPolicy(s.param.proxy.PendingPolicy) {
+		return s, errors.New("unknown pending policy " + s.param.proxy.PendingPolicy)
+	}
+	if s.param.proxy.PendingPolicy == pendingPersist && s.param.proxy.PendingFile == "" {
+		return s, errors.New("pending policy persist needs a pending file")
+	}
+
+	clients, err := s.newHTTPClients()
+	if err != nil {
+		return s, err
+	}
+	s.clients = clients
+
+	if m, ok := s.mappingSource.(mappingURL); ok {
+		m.client = clients.mapping
+		s.mappingSource = m
+	}
+
+	if s.param.proxy.GitCache != "" {
+		log.Printf("git mirror cache: %s\n", s.param.proxy.GitCache)
+
+		mirror, err := newGitMirror(s.param.proxy.GitCache)
+		if err != nil {
+			return s, err
+		}
+		s.mirror = mirror
+	}
+
+	if s.param.proxy.GenericHooks != "" {
+		hooks, err := loadGenericHooks(s.param.proxy.GenericHooks)
+		if err != nil {
+			return s, err
+		}
+		s.genericHooks = hooks
+
+		log.Printf("generic hooks: %d\n", len(hooks))
+	}
+
+	if s.param.proxy.StatusHosts != "" {
+		hosts, err := loadStatusHosts(s.param.proxy.StatusHosts)
+		if err != nil {
+			return s, err
+		}
+		s.status = newStatusReporter(hosts, s.param.proxy.StatusName, clients.api)
+
+		log.Printf("commit status hosts: %d\n", len(hosts))
+	}
+
+	if s.param.proxy.AuditFile != "" {
+		log.Printf("audit file: %s\n", s.param.proxy.AuditFile)
+
+		audit, err := newAuditLog(s.param.proxy.AuditFile, int64(s.param.proxy.AuditMaxSize)<<20, s.param.proxy.AuditDaily)
+		if err != nil {
+			return s, err
+		}
+		s.audit = audit
+	}
+
+	if s.param.proxy.Deliveries > 0 {
+		deliveries, err := newDeliveryLog(s.param.proxy.Deliveries, s.param.proxy.DeliveriesFile)
+		if err != nil {
+			return s, err
+		}
+		s.deliveries = deliveries
+	}
+
+	p, err := newPoller(s.param.proxy.PollState, s.param.proxy.PollInterval)
+	if err != nil {
+		return s, err
+	}
+	s.poller = p
+
 	return s, nil
 }
 
@@ -115,14 +305,61 @@ func (s *server) parseFlags(args []string) error {
 	flags.StringVar(&s.param.jenkins.User, "jenkins-user", "", "jenkins username")
 	flags.StringVar(&s.param.jenkins.Token, "jenkins-token", "", "token for user or root token to trigger anonymously")
 	flags.StringVar(&s.param.jenkins.Multi, "jenkins-multi", "", "root folder or job name")
+	flags.BoolVar(&s.param.jenkins.FollowQueue, "jenkins-follow-queue", true, "follow the queue item of a trigger until the build started")
+	flags.BoolVar(&s.param.jenkins.FollowResult, "jenkins-follow-result", false, "follow started builds until they have a result")
+	flags.DurationVar(&s.param.jenkins.PollInterval, "jenkins-poll-interval", defJenkinsPoll, "interval to poll queue items and builds")
+	flags.DurationVar(&s.param.jenkins.QueueTimeout, "jenkins-queue-timeout", defQueueTimeout, "time to wait for a queue item to start a build")
+	flags.DurationVar(&s.param.jenkins.ResultTimeout, "jenkins-result-timeout", defResultTimeout, "time to wait for a build to finish")
+	flags.DurationVar(&s.param.jenkins.ProbeInterval, "jenkins-probe-interval", defProbeInt, "interval to probe jenkins for the readiness check")
+	flags.BoolVar(&s.param.jenkins.RequireReady, "jenkins-required", false, "report not ready while jenkins is unreachable")
+	flags.BoolVar(&s.param.jenkins.Insecure, "jenkins-insecure", false, "do not verify the certificate of jenkins")
+	flags.StringVar(&s.param.jenkins.ClientCert, "jenkins-client-cert", "", "pem encoded client certificate presented to jenkins")
+	flags.StringVar(&s.param.jenkins.ClientKey, "jenkins-client-key", "", "pem encoded private key of the jenkins client certificate")
+
+	flags.StringVar(&s.param.gitlab.URL, "gitlab-url", "", "gitlab url for api requests, required to complete truncated push events")
+	flags.StringVar(&s.param.gitlab.Token, "gitlab-token", "", "gitlab api token to complete truncated push events")
+
+	flags.StringVar(&s.param.gerrit.URL, "gerrit-url", "", "gerrit base url to build clone urls of gerrit projects")
+
+	flags.StringVar(&s.param.azure.User, "azure-user", "", "basic auth user expected from azure devops service hooks")
+	flags.StringVar(&s.param.azure.Password, "azure-password", "", "basic auth password expected from azure devops service hooks")
 
 	flags.IntVar(&s.param.proxy.QuietPeriod, "quietperiod", defQp, "defines the time trigger-proxy will wait until the job is triggered")
 	flags.BoolVar(&s.param.proxy.FileMatching, "filematch", false, "try to match for file names")
 	flags.StringVar(&s.param.proxy.SemanticRepo, "semanticrepo", "", "repo prefix to handle as component repository")
 	flags.IntVar(&s.param.proxy.port, "port", defPort, "defines the http port to listen on")
+	flags.StringVar(&s.param.proxy.TLSCert, "tls-cert", "", "pem encoded certificate to serve https with, reloaded when changed")
+	flags.StringVar(&s.param.proxy.TLSKey, "tls-key", "", "pem encoded private key of the certificate")
+	flags.StringVar(&s.param.proxy.CABundle, "ca-bundle", "", "pem encoded CA bundle trusted in addition to the system CAs for outgoing https requests")
+	flags.BoolVar(&s.param.proxy.MappingInsecure, "mapping-insecure", false, "do not verify the certificate of the mapping url")
+	flags.StringVar(&s.param.proxy.TLSClientCA, "tls-client-ca", "", "pem encoded CA bundle to verify client certificates with, every client needs one if set")
+	flags.StringVar(&s.param.proxy.PollState, "poll-state", "poll-state.json", "file to store the branch heads of polled repos")
+	flags.DurationVar(&s.param.proxy.PollInterval, "poll-interval", defPollInt, "default interval to poll repos of the poll section")
+	flags.StringVar(&s.param.proxy.GenericHooks, "generic-hooks", "", "json file with the configuration of generic hooks")
+	flags.StringVar(&s.param.proxy.LogFormat, "log-format", "text", "log format, text or json")
+	flags.StringVar(&s.param.proxy.LogLevel, "log-level", "info", "minimum log level, debug, info, warn or error")
+	flags.StringVar(&s.param.proxy.AuditFile, "audit-file", "", "file to append an audit record of every trigger decision to, disabled if empty")
+	flags.IntVar(&s.param.proxy.AuditMaxSize, "audit-max-size", defAuditSize, "size in megabytes at which the audit file is rotated, 0 disables rotation by size")
+	flags.BoolVar(&s.param.proxy.AuditDaily, "audit-daily", false, "rotate the audit file every day")
+	flags.IntVar(&s.param.proxy.Deliveries, "deliveries", defDeliveries, "number of recent deliveries kept for the admin api, 0 disables the history")
+	flags.StringVar(&s.param.proxy.DeliveriesFile, "deliveries-file", "", "file to persist the recent deliveries in, kept in memory only if empty")
+	flags.StringVar(&s.param.proxy.StatusHosts, "status-hosts", "", "json file with the api tokens of git hosts to report commit status to, disabled if empty")
+	flags.StringVar(&s.param.proxy.StatusName, "status-name", "trigger-proxy", "name of the reported commit status, followed by the job")
+	flags.StringVar(&s.param.proxy.AdminToken, "admin-token", "", "bearer token for the admin api, disabled if empty")
+	flags.DurationVar(&s.param.proxy.ReleaseInterval, "release-interval", defReleaseInt, "interval between held jobs triggered when triggering resumes")
+	flags.StringVar(&s.param.proxy.PendingPolicy, "pending-policy", pendingFire, "what to do with pending jobs on shutdown: fire, persist or drop")
+	flags.StringVar(&s.param.proxy.PendingFile, "pending-file", "pending-jobs.json", "file to persist pending jobs in on shutdown, restored on start")
+	flags.DurationVar(&s.param.proxy.ShutdownTimeout, "shutdown-timeout", defShutdownTimeout, "time to wait for running requests on shutdown")
+	flags.StringVar(&s.param.proxy.GitCache, "git-cache", "", "directory for git mirrors to compute changed files of get requests")
+
+	mrActions := flags.String("mr-actions", defMRActions, "comma separated list of merge request actions which trigger jobs")
+	skipMarkers := flags.String("skip-markers", defSkipMarkers, "comma separated list of commit message markers which skip triggers")
+	skipAuthors := flags.String("skip-authors", "", "comma separated list of author emails and usernames whose pushes don't trigger jobs")
+	ceTypes := flags.String("cloudevent-types", defCloudEventType, "comma separated list of accepted cloud event types")
+	prActions := flags.String("pr-actions", defPRActions, "comma separated list of pull request actions which trigger jobs")
+	flags.BoolVar(&s.param.proxy.PRSkipDraft, "pr-skip-draft", true, "do not trigger jobs for draft pull requests")
 
 	refreshInterval := flags.Int("mappingrefresh", defInt, "refresh interval in minutes to check for modified mapping file")
-	s.mappingRefreshInterval = time.Duration(*refreshInterval) * time.Minute
 
 	var (
 		mFile string
@@ -135,6 +372,13 @@ func (s *server) parseFlags(args []string) error {
 		return err
 	}
 
+	s.mappingRefreshInterval = time.Duration(*refreshInterval) * time.Minute
+	s.param.proxy.MRActions = splitList(*mrActions)
+	s.param.proxy.PRActions = splitList(*prActions)
+	s.param.proxy.CloudEventTypes = splitList(*ceTypes)
+	s.param.proxy.SkipMarkers = splitList(*skipMarkers)
+	s.param.proxy.SkipAuthors = splitList(*skipAuthors)
+
 	// if an URL is defined, use that
 	if len(mURL) > 0 {
 		s.mappingSource = mappingURL{
@@ -161,20 +405,50 @@ func run(args []string) error {
 		return err
 	}
 
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+
 	// if the first refresh fails, fail early
 	if err := s.refreshMapping(); err != nil {
 		return err
 	}
 
-	s.createRefreshJob()
+	if err := s.restorePendingJobs(); err != nil {
+		return err
+	}
 
-	http.HandleFunc("/", s.handlePlainGet())
-	http.HandleFunc("/json", s.handleJSONPost())
-	http.HandleFunc("/readyz", s.handleReadiness())
+	s.createRefreshJob()
+	s.createPollJob()
+	s.createPauseSignalJob()
+
+	http.HandleFunc("/", instrument("/", s.recordDelivery("/", s.handlePlainGet())))
+	http.HandleFunc("/json", instrument("/json", s.recordDelivery("/json", s.handleJSONPost())))
+	http.HandleFunc("/event", instrument("/event", s.recordDelivery("/event", s.handleEvent())))
+	http.HandleFunc("/explain", instrument("/explain", s.handleExplain()))
+	http.HandleFunc("/hook/", instrument("/hook/", s.recordDelivery("/hook/", s.handleGenericHook())))
+	http.HandleFunc("/cloudevents", instrument("/cloudevents", s.recordDelivery("/cloudevents", s.handleCloudEvent())))
+	http.HandleFunc("/gerrit", instrument("/gerrit", s.recordDelivery("/gerrit", s.handleGerrit())))
+	http.HandleFunc("/azure", instrument("/azure", s.recordDelivery("/azure", s.handleAzurePush())))
+	http.HandleFunc("/livez", instrument("/livez", s.handleLiveness()))
+	http.HandleFunc("/readyz", instrument("/readyz", s.handleReadiness()))
+	http.HandleFunc("/admin/", instrument("/admin/", s.handleAdmin()))
+	http.HandleFunc("/metrics", s.handleMetrics())
+
+	var handler http.Handler = http.DefaultServeMux
+	if tlsConfig != nil && tlsConfig.ClientCAs != nil {
+		log.Println("requiring client certificates")
+
+		handler = requireClientCert(handler)
+	}
 
 	port := strconv.Itoa(s.param.proxy.port)
-	log.Println("serving on port " + port)
-	http.ListenAndServe(":"+port, nil)
+	if tlsConfig != nil {
+		log.Println("serving https on port " + port)
+	} else {
+		log.Println("serving on port " + port)
+	}
 
-	return nil
+	return s.serve(&http.Server{Addr: ":" + port, Handler: handler, TLSConfig: tlsConfig})
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	auditEventRecord   = "event"   // an event was matched against the mapping
+	auditTriggerRecord = "trigger" // a job was triggered in jenkins
+	auditBuildRecord   = "build"   // a triggered build reached its final state
+)
+
+// auditEvent summarizes the event which led to a trigger decision
+type auditEvent struct {
+	Provider string `json:"provider"`
+	Repo     string `json:"repo,omitempty"`
+	Ref      string `json:"ref,omitempty"`
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+	Sender   string `json:"sender,omitempty"`
+}
+
+// auditMatch is a mapping row matched by a key of an event
+type auditMatch struct {
+	Key     string   `json:"key"`
+	Mapping string   `json:"mapping"`
+	Jobs    []string `json:"jobs"`
+}
+
+// auditResponse is the answer of jenkins to a trigger
+type auditResponse struct {
+	URL       string     `json:"url"`
+	Params    url.Values `json:"params,omitempty"`
+	Status    int        `json:"status,omitempty"`
+	QueueItem string     `json:"queue_item,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// auditRecord is a line of the audit log
+type auditRecord struct {
+	Time       time.Time      `json:"time"`
+	Type       string         `json:"type"`
+	RequestID  string         `json:"request_id,omitempty"`
+	RequestIDs []string       `json:"request_ids,omitempty"`
+	Event      *auditEvent    `json:"event,omitempty"`
+	Matches    []auditMatch   `json:"matches,omitempty"`
+	Decisions  []scheduledJob `json:"decisions,omitempty"`
+	Job        string         `json:"job,omitempty"`
+	Jenkins    *auditResponse `json:"jenkins,omitempty"`
+	Build      *trackedBuild  `json:"build,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// auditLog appends records as json lines to a file, which is rotated when
+// it exceeds its maximum size or, if daily is set, when the day changes
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	daily   bool
+	file    *os.File
+	size    int64
+	day     string
+}
+
+// newAuditLog opens the audit log at path. A maxSize of 0 disables the
+// rotation by size.
+func newAuditLog(path string, maxSize int64, daily bool) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: maxSize, daily: daily}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *auditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	a.file = file
+	a.size = info.Size()
+	a.day = info.ModTime().Format("2006-01-02")
+	if a.size == 0 {
+		a.day = time.Now().Format("2006-01-02")
+	}
+
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and opens a new one
+func (a *auditLog) rotate(now time.Time) error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(a.path, a.path+"."+now.Format("20060102T150405.000000000")); err != nil {
+		return err
+	}
+
+	return a.open()
+}
+
+// write appends rec to the audit log. Writing to a nil audit log does nothing.
+func (a *auditLog) write(rec auditRecord) {
+	if a == nil {
+		return
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		defaultLogger.error("encoding audit record failed", "error", err)
+
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	full := a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize
+	newDay := a.daily && a.size > 0 && now.Format("2006-01-02") != a.day
+	if full || newDay {
+		if err := a.rotate(now); err != nil {
+			defaultLogger.error("rotating audit log failed", "error", err)
+
+			return
+		}
+	}
+
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	if err != nil {
+		defaultLogger.error("writing audit record failed", "error", err)
+	}
+}
+
+type auditEventKey struct{}
+
+// withAuditEvent returns a context carrying the summary of the event
+// handled with it
+func withAuditEvent(ctx context.Context, ev auditEvent) context.Context {
+	return context.WithValue(ctx, auditEventKey{}, ev)
+}
+
+// auditEventFrom returns the event summary of ctx for repo and ref
+func auditEventFrom(ctx context.Context, repo, ref string) *auditEvent {
+	ev, _ := ctx.Value(auditEventKey{}).(auditEvent)
+	ev.Repo = repo
+	ev.Ref = ref
+
+	return &ev
+}
+
+// newAuditEvent summarizes an event of provider received with r
+func newAuditEvent(r *http.Request, provider, before, after string) auditEvent {
+	sender, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sender = r.RemoteAddr
+	}
+
+	return auditEvent{Provider: provider, Before: before, After: after, Sender: sender}
+}
+
+type requestIDsKey struct{}
+
+// withRequestIDs returns a context carrying the ids of the requests which
+// scheduled a job
+func withRequestIDs(ctx context.Context, ids []string) context.Context {
+	return context.WithValue(ctx, requestIDsKey{}, ids)
+}
+
+func requestIDsFrom(ctx context.Context) []string {
+	ids, _ := ctx.Value(requestIDsKey{}).([]string)
+
+	return ids
+}
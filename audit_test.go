@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func readAuditRecords(t *testing.T, path string) []auditRecord {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func Test_auditLog_rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	a, err := newAuditLog(path, 200, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		a.write(auditRecord{Type: auditTriggerRecord, Job: strings.Repeat("j", 80)})
+	}
+
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Errorf("audit log rotated into %d files, want 2", len(rotated))
+	}
+	if records := readAuditRecords(t, path); len(records) != 1 {
+		t.Errorf("current audit file holds %d records, want 1", len(records))
+	}
+
+	var nilLog *auditLog
+	nilLog.write(auditRecord{})
+}
+
+func Test_server_auditTrigger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	audit, err := newAuditLog(path, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "http://jenkins/queue/item/7/")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer stub.Close()
+
+	s := server{
+		mapping:        map[string][]string{"repo|master": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		audit:          audit,
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL, Token: "secret"},
+			proxy:   proxy{QuietPeriod: 60},
+		},
+	}
+
+	r := httptest.NewRequest("GET", "/?repo=repo&branch=master&before=aaa&after=bbb", nil)
+	r.Header.Set("X-Request-ID", "req1")
+	instrument("/", s.handlePlainGet())(httptest.NewRecorder(), r)
+	instrument("/", s.handlePlainGet())(httptest.NewRecorder(), httptest.NewRequest("GET", "/?repo=other", nil))
+
+	if found, triggered := s.fireJob("job"); !found || !triggered {
+		t.Fatalf("fireJob() = %v, %v", found, triggered)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 3 {
+		t.Fatalf("audit log holds %d records, want 3", len(records))
+	}
+
+	ev := records[0]
+	if ev.Type != auditEventRecord || ev.RequestID != "req1" || ev.Event == nil {
+		t.Fatalf("event record = %+v", ev)
+	}
+	if want := (auditEvent{Provider: "get", Repo: "repo", Ref: "master", Before: "aaa", After: "bbb", Sender: "192.0.2.1"}); *ev.Event != want {
+		t.Errorf("event = %+v, want %+v", *ev.Event, want)
+	}
+	if len(ev.Matches) != 1 || ev.Matches[0].Mapping != "repo|master" || len(ev.Decisions) != 1 || ev.Decisions[0].Status != jobScheduled {
+		t.Errorf("event record matches %+v, decisions %+v", ev.Matches, ev.Decisions)
+	}
+
+	if miss := records[1]; miss.Error != errNoMappings.Error() || len(miss.Decisions) != 0 {
+		t.Errorf("miss record = %+v", miss)
+	}
+
+	trigger := records[2]
+	if trigger.Type != auditTriggerRecord || trigger.Job != "job" || len(trigger.RequestIDs) != 1 || trigger.RequestIDs[0] != "req1" {
+		t.Fatalf("trigger record = %+v", trigger)
+	}
+	if trigger.Jenkins.Status != http.StatusCreated || trigger.Jenkins.QueueItem != "http://jenkins/queue/item/7/" {
+		t.Errorf("jenkins response = %+v", trigger.Jenkins)
+	}
+	if strings.Contains(trigger.Jenkins.URL, "secret") {
+		t.Errorf("audit record leaks the jenkins token: %s", trigger.Jenkins.URL)
+	}
+}
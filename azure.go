@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// azureRefUpdate is a branch updated by an azure devops push
+type azureRefUpdate struct {
+	Branch string
+	Before string
+	After  string
+}
+
+// azurePush holds the relevant parts of an azure devops git.push event
+type azurePush struct {
+	Repos      []string
+	RefUpdates []azureRefUpdate
+	Pusher     string
+	Commits    []commit
+}
+
+func parseAzurePush(r *http.Request) (azurePush, error) {
+	push := azurePush{Repos: []string{}, RefUpdates: []azureRefUpdate{}, Commits: []commit{}}
+
+	type azureRepository struct {
+		RemoteURL string `json:"remoteUrl"`
+		SSHURL    string `json:"sshUrl"`
+	}
+
+	type azureRef struct {
+		Name        string
+		OldObjectID string `json:"oldObjectId"`
+		NewObjectID string `json:"newObjectId"`
+	}
+
+	type azureAuthor struct {
+		Name  string
+		Email string
+	}
+
+	type azureCommit struct {
+		Comment string
+		Author  azureAuthor
+	}
+
+	type azureIdentity struct {
+		UniqueName string `json:"uniqueName"`
+	}
+
+	type azureResource struct {
+		Commits    []azureCommit
+		RefUpdates []azureRef `json:"refUpdates"`
+		Repository azureRepository
+		PushedBy   azureIdentity `json:"pushedBy"`
+	}
+
+	type azureServiceHook struct {
+		EventType string `json:"eventType"`
+		Resource  azureResource
+	}
+
+	l := loggerFrom(r.Context())
+
+	l.info("parsing azure devops push")
+
+	var h azureServiceHook
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &h); err != nil || h.EventType != "git.push" {
+		return push, errors.New("bad request")
+	}
+
+	if h.Resource.Repository.RemoteURL != "" {
+		push.Repos = append(push.Repos, h.Resource.Repository.RemoteURL)
+	}
+	if h.Resource.Repository.SSHURL != "" {
+		push.Repos = append(push.Repos, h.Resource.Repository.SSHURL)
+	}
+
+	if len(push.Repos) == 0 {
+		return push, errors.New("repo is missing")
+	}
+
+	for _, ref := range h.Resource.RefUpdates {
+		if !strings.HasPrefix(ref.Name, "refs/heads/") || isNullCommit(ref.NewObjectID) {
+			l.info("skipping ref update", "ref", ref.Name)
+			continue
+		}
+
+		push.RefUpdates = append(push.RefUpdates, azureRefUpdate{
+			Branch: strings.TrimPrefix(ref.Name, "refs/heads/"),
+			Before: ref.OldObjectID,
+			After:  ref.NewObjectID,
+		})
+	}
+
+	push.Pusher = h.Resource.PushedBy.UniqueName
+	for _, c := range h.Resource.Commits {
+		push.Commits = append(push.Commits, newCommit(c.Comment, c.Author.Name, c.Author.Email))
+	}
+
+	l.info("parsed azure devops push", "branch_updates", len(push.RefUpdates))
+
+	return push, nil
+}
+
+// checkAzureAuth reports whether the request carries the configured azure
+// devops credentials. Without configured credentials every request passes.
+func (s *server) checkAzureAuth(r *http.Request) bool {
+	if s.param.azure.User == "" && s.param.azure.Password == "" {
+		return true
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.param.azure.User)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.param.azure.Password)) == 1
+
+	return userOK && passwordOK
+}
+
+func (s *server) handleAzurePush() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		loggerFrom(ctx).info("handling new azure devops event")
+
+		if !s.checkAzureAuth(r) {
+			loggerFrom(ctx).warn("aborting request handling", "error", "invalid credentials")
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="trigger-proxy"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		push, err := parseAzurePush(r)
+		if err != nil {
+			metrics.inc(metricParseFailures, "azure")
+			writeBadRequest(ctx, w, err)
+
+			return
+		}
+
+		resp := newTriggerResponse()
+
+		if s.skipPush(ctx, push.Pusher, push.Commits, true) {
+			loggerFrom(ctx).info("skipping push", "pusher", push.Pusher)
+			resp.Skipped = "skip marker or ignored author"
+			writeTriggerResponse(w, resp)
+
+			return
+		}
+
+		for _, ref := range push.RefUpdates {
+			refCtx := withAuditEvent(ctx, newAuditEvent(r, "azure", ref.Before, ref.After))
+			for _, repo := range push.Repos {
+				jobs, err := s.processRefUpdate(refCtx, repo, ref.Branch, nil, ref.Before, ref.After, nil)
+				resp.add(repo, ref.Branch, jobs, err)
+			}
+		}
+
+		writeTriggerResponse(w, resp)
+
+		loggerFrom(ctx).info("handling of request finished")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const azureGitPush = `{
+	"subscriptionId": "00000000-0000-0000-0000-000000000000",
+	"eventType": "git.push",
+	"publisherId": "tfs",
+	"resource": {
+	  "commits": [
+		{
+		  "commitId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74",
+		  "author": {
+			"name": "Jamal Hartnett",
+			"email": "fabrikamfiber4@hotmail.com",
+			"date": "2015-02-25T19:01:00Z"
+		  },
+		  "comment": "Fixed bug in web.config file"
+		}
+	  ],
+	  "refUpdates": [
+		{
+		  "name": "refs/heads/master",
+		  "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+		  "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+		},
+		{
+		  "name": "refs/heads/release",
+		  "oldObjectId": "0000000000000000000000000000000000000000",
+		  "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+		},
+		{
+		  "name": "refs/heads/obsolete",
+		  "oldObjectId": "aad331d8d3b131fa9ae03cf5e53965b51942618a",
+		  "newObjectId": "0000000000000000000000000000000000000000"
+		},
+		{
+		  "name": "refs/tags/v1",
+		  "oldObjectId": "0000000000000000000000000000000000000000",
+		  "newObjectId": "33b55f7cb7e7e245323987634f960cf4a6e6bc74"
+		}
+	  ],
+	  "repository": {
+		"name": "Fabrikam-Fiber-Git",
+		"remoteUrl": "https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git"
+	  },
+	  "pushedBy": {
+		"displayName": "Jamal Hartnett",
+		"uniqueName": "fabrikamfiber4@hotmail.com"
+	  }
+	}
+  }`
+
+func Test_parseAzurePush(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/azure", strings.NewReader(azureGitPush))
+	got, err := parseAzurePush(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := azurePush{
+		Repos: []string{"https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git"},
+		RefUpdates: []azureRefUpdate{
+			{Branch: "master", Before: "aad331d8d3b131fa9ae03cf5e53965b51942618a", After: "33b55f7cb7e7e245323987634f960cf4a6e6bc74"},
+			{Branch: "release", Before: "0000000000000000000000000000000000000000", After: "33b55f7cb7e7e245323987634f960cf4a6e6bc74"},
+		},
+		Pusher: "fabrikamfiber4@hotmail.com",
+		Commits: []commit{
+			{Message: "Fixed bug in web.config file", AuthorName: "Jamal Hartnett", AuthorEmail: "fabrikamfiber4@hotmail.com"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAzurePush() = %+v, want %+v", got, want)
+	}
+
+	r, _ = http.NewRequest("POST", "/azure", strings.NewReader(`{"eventType": "git.pullrequest.created"}`))
+	if _, err := parseAzurePush(r); err == nil {
+		t.Error("parseAzurePush() accepted a pull request event")
+	}
+}
+
+func Test_server_handleAzurePush(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        string
+		password    string
+		skipAuthors []string
+		filematch   bool
+		wantHTTP    int
+		wantHits    int
+	}{
+		{"valid_credentials", "hook", "secret", nil, false, http.StatusAccepted, 2},
+		{"wrong_password", "hook", "wrong", nil, false, http.StatusUnauthorized, 0},
+		{"no_credentials", "", "", nil, false, http.StatusUnauthorized, 0},
+		{"skipped_author", "hook", "secret", []string{"fabrikamfiber4@hotmail.com"}, false, http.StatusOK, 0},
+		{"filematch_without_mirror", "hook", "secret", nil, true, http.StatusAccepted, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				mapping: map[string][]string{
+					"https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git|master":      {"job1"},
+					"https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git|release":     {"job2"},
+					"https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git|obsolete":    {"job3"},
+					"https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git|master|src":  {"job4"},
+					"https://dev.azure.com/fabrikam/_git/Fabrikam-Fiber-Git|release|doc": {"job5"},
+				},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param: parameters{
+					azure: azure{User: "hook", Password: "secret"},
+					proxy: proxy{QuietPeriod: 5, SkipAuthors: tt.skipAuthors, FileMatching: tt.filematch},
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/azure", strings.NewReader(azureGitPush))
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			http.HandlerFunc(s.handleAzurePush()).ServeHTTP(w, r)
+			if status := w.Result().StatusCode; status != tt.wantHTTP {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantHTTP)
+			}
+			if hits := len(s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			if tt.wantHTTP == http.StatusAccepted {
+				var resp triggerResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if len(resp.Repos) != 2 || resp.Repos[0].Branch != "master" || resp.Repos[1].Branch != "release" {
+					t.Errorf("handler answered %+v, want results of master and release", resp)
+				}
+			}
+			for _, pj := range s.timeKeeper {
+				pj.timer.Stop()
+			}
+		})
+	}
+}
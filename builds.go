@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxTrackedBuilds = 100 // number of triggered builds kept for the admin api
+
+	buildQueued    = "queued"    // the trigger waits in the jenkins queue
+	buildStarted   = "started"   // the queue item became a build
+	buildFinished  = "finished"  // the build has a result
+	buildCancelled = "cancelled" // the queue item was cancelled
+	buildTimeout   = "timeout"   // the queue item or build didn't progress in time
+)
+
+// trackedBuild follows a trigger from the jenkins queue to its build
+type trackedBuild struct {
+	Job         string     `json:"job"`
+	RequestIDs  []string   `json:"request_ids,omitempty"`
+	QueueItem   string     `json:"queue_item"`
+	State       string     `json:"state"`
+	BuildNumber int        `json:"build_number,omitempty"`
+	BuildURL    string     `json:"build_url,omitempty"`
+	Result      string     `json:"result,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	TriggeredAt time.Time  `json:"triggered_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// buildTracker keeps the most recently triggered builds
+type buildTracker struct {
+	mu     sync.Mutex
+	builds []*trackedBuild
+}
+
+func newBuildTracker() *buildTracker {
+	return &buildTracker{}
+}
+
+// add starts tracking b, dropping the oldest build if too many are tracked
+func (t *buildTracker) add(b *trackedBuild) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.builds = append(t.builds, b)
+	if len(t.builds) > maxTrackedBuilds {
+		t.builds = append([]*trackedBuild{}, t.builds[len(t.builds)-maxTrackedBuilds:]...)
+	}
+}
+
+// update changes b while holding the lock of the tracker
+func (t *buildTracker) update(b *trackedBuild, change func(b *trackedBuild)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	change(b)
+}
+
+// list returns copies of the tracked builds, newest first
+func (t *buildTracker) list() []trackedBuild {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]trackedBuild, 0, len(t.builds))
+	for i := len(t.builds) - 1; i >= 0; i-- {
+		list = append(list, *t.builds[i])
+	}
+
+	return list
+}
+
+// jenkinsQueueItem holds the relevant parts of a jenkins queue item
+type jenkinsQueueItem struct {
+	Cancelled  bool
+	Why        string
+	Executable *struct {
+		Number int
+		URL    string
+	}
+}
+
+// jenkinsBuild holds the relevant parts of a jenkins build
+type jenkinsBuild struct {
+	Building bool
+	Result   string
+}
+
+// jenkinsAPI fetches the json api of the jenkins object at objectURL
+func (s *server) jenkinsAPI(objectURL string, v interface{}) error {
+	header := http.Header{}
+	if s.param.jenkins.User != "" {
+		credentials := s.param.jenkins.User + ":" + s.param.jenkins.Token
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	body, err := httpGetWithHeader(s.jenkinsClient(), strings.TrimSuffix(objectURL, "/")+"/api/json", header)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// trackBuild follows the queue item of a triggered job in the background,
+// if following the queue is enabled
+func (s *server) trackBuild(ctx context.Context, job, queueItem string) {
+	if s.builds == nil || !s.param.jenkins.FollowQueue || queueItem == "" {
+		return
+	}
+
+	b := &trackedBuild{
+		Job:         job,
+		RequestIDs:  requestIDsFrom(ctx),
+		QueueItem:   queueItem,
+		State:       buildQueued,
+		TriggeredAt: time.Now(),
+	}
+	s.builds.add(b)
+
+	go s.followBuild(ctx, b)
+}
+
+// followBuild polls the queue item of b until it becomes a build and, if
+// enabled, the build until it has a result
+func (s *server) followBuild(ctx context.Context, b *trackedBuild) {
+	l := loggerFrom(ctx).with("queue_item", b.QueueItem)
+
+	deadline := time.Now().Add(s.param.jenkins.QueueTimeout)
+	for {
+		var item jenkinsQueueItem
+		err := s.jenkinsAPI(b.QueueItem, &item)
+
+		switch {
+		case err == nil && item.Cancelled:
+			l.warn("queue item cancelled")
+			s.finishBuild(b, buildCancelled, "", "")
+
+			return
+		case err == nil && item.Executable != nil:
+			l.info("build started", "build_number", item.Executable.Number, "build_url", item.Executable.URL)
+			s.builds.update(b, func(b *trackedBuild) {
+				b.State = buildStarted
+				b.BuildNumber = item.Executable.Number
+				b.BuildURL = item.Executable.URL
+				now := time.Now()
+				b.StartedAt = &now
+			})
+			metrics.inc(metricBuilds, b.Job, buildStarted)
+			metrics.observe(metricQueueWait, time.Since(b.TriggeredAt).Seconds())
+			s.status.report(ctx, b.Job, statusPending, item.Executable.URL, "Jenkins build #"+strconv.Itoa(item.Executable.Number)+" started")
+		case err != nil:
+			l.debug("polling queue item failed", "error", err)
+		default:
+			l.debug("job waits in queue", "why", item.Why)
+		}
+
+		if err == nil && item.Executable != nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			l.warn("queue item didn't start a build in time")
+			s.finishBuild(b, buildTimeout, "", errorString(err))
+
+			return
+		}
+
+		time.Sleep(s.param.jenkins.PollInterval)
+	}
+
+	if !s.param.jenkins.FollowResult {
+		return
+	}
+
+	deadline = time.Now().Add(s.param.jenkins.ResultTimeout)
+	for {
+		time.Sleep(s.param.jenkins.PollInterval)
+
+		var build jenkinsBuild
+		err := s.jenkinsAPI(b.BuildURL, &build)
+		if err == nil && !build.Building && build.Result != "" {
+			l.info("build finished", "build_url", b.BuildURL, "result", build.Result)
+			s.finishBuild(b, buildFinished, build.Result, "")
+
+			return
+		} else if err != nil {
+			l.debug("polling build failed", "error", err)
+		}
+
+		if time.Now().After(deadline) {
+			l.warn("build didn't finish in time", "build_url", b.BuildURL)
+			s.finishBuild(b, buildTimeout, "", errorString(err))
+
+			return
+		}
+	}
+}
+
+// finishBuild records the final state of b
+func (s *server) finishBuild(b *trackedBuild, state, result, errMsg string) {
+	now := time.Now()
+
+	var final trackedBuild
+	s.builds.update(b, func(b *trackedBuild) {
+		b.State = state
+		b.Result = result
+		b.Error = errMsg
+		b.FinishedAt = &now
+		final = *b
+	})
+
+	metrics.inc(metricBuilds, b.Job, state)
+	if result != "" {
+		metrics.inc(metricBuildResults, b.Job, result)
+	}
+
+	s.audit.write(auditRecord{
+		Time:       now,
+		Type:       auditBuildRecord,
+		RequestIDs: final.RequestIDs,
+		Job:        final.Job,
+		Build:      &final,
+	})
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newMockJenkins returns a jenkins which queues triggers of job and starts
+// build 5 on the second poll of the queue item, unless cancel is set
+func newMockJenkins(job string, cancel bool) *httptest.Server {
+	var (
+		mu         sync.Mutex
+		queuePolls int
+		buildPolls int
+	)
+
+	var stub *httptest.Server
+	stub = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/job/" + job + "/build":
+			w.Header().Set("Location", stub.URL+"/queue/item/1/")
+			w.WriteHeader(http.StatusCreated)
+		case "/queue/item/1/api/json":
+			queuePolls++
+			switch {
+			case cancel:
+				fmt.Fprint(w, `{"cancelled": true}`)
+			case queuePolls < 2:
+				fmt.Fprint(w, `{"why": "Waiting for next available executor"}`)
+			default:
+				fmt.Fprintf(w, `{"executable": {"number": 5, "url": "%s/job/%s/5/"}}`, stub.URL, job)
+			}
+		case "/job/" + job + "/5/api/json":
+			buildPolls++
+			if buildPolls < 2 {
+				fmt.Fprint(w, `{"building": true, "result": null}`)
+			} else {
+				fmt.Fprint(w, `{"building": false, "result": "SUCCESS"}`)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return stub
+}
+
+func Test_server_trackBuild(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancel     bool
+		wantState  string
+		wantNumber int
+		wantResult string
+	}{
+		{"finished", false, buildFinished, 5, "SUCCESS"},
+		{"cancelled", true, buildCancelled, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newMockJenkins("job", tt.cancel)
+			defer stub.Close()
+
+			s := &server{
+				builds: newBuildTracker(),
+				param: parameters{
+					jenkins: jenkins{
+						URL:           stub.URL,
+						Token:         "token",
+						FollowQueue:   true,
+						FollowResult:  true,
+						PollInterval:  10 * time.Millisecond,
+						QueueTimeout:  2 * time.Second,
+						ResultTimeout: 2 * time.Second,
+					},
+				},
+			}
+
+			if !s.triggerJob(withRequestIDs(context.Background(), []string{"req1"}), "job", nil) {
+				t.Fatal("trigger failed")
+			}
+
+			var b trackedBuild
+			deadline := time.Now().Add(3 * time.Second)
+			for {
+				b = s.builds.list()[0]
+				if b.FinishedAt != nil || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if b.State != tt.wantState || b.BuildNumber != tt.wantNumber || b.Result != tt.wantResult {
+				t.Errorf("tracked build = %+v", b)
+			}
+			if b.QueueItem != stub.URL+"/queue/item/1/" || len(b.RequestIDs) != 1 {
+				t.Errorf("tracked build = %+v", b)
+			}
+			if tt.wantNumber > 0 && b.BuildURL != stub.URL+"/job/job/5/" {
+				t.Errorf("build url = %s", b.BuildURL)
+			}
+		})
+	}
+}
+
+func Test_buildTracker_add(t *testing.T) {
+	tracker := newBuildTracker()
+	for i := 0; i < maxTrackedBuilds+5; i++ {
+		tracker.add(&trackedBuild{Job: fmt.Sprint(i)})
+	}
+
+	list := tracker.list()
+	if len(list) != maxTrackedBuilds || list[0].Job != fmt.Sprint(maxTrackedBuilds+4) {
+		t.Errorf("tracker keeps %d builds, newest %s", len(list), list[0].Job)
+	}
+}
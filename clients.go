@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const clientTimeout = 5 * time.Second // timeout of outgoing requests
+
+// defaultHTTPClient verifies certificates against the system CAs. It is
+// used if no clients are configured.
+var defaultHTTPClient = &http.Client{Timeout: clientTimeout}
+
+// httpClients are the clients shared by all outgoing requests of a target
+type httpClients struct {
+	jenkins *http.Client
+	mapping *http.Client
+	// api is used for the apis of GitLab and GitHub
+	api *http.Client
+}
+
+// newHTTPClient returns a client trusting roots, or the system CAs if roots
+// is nil, and presenting certs as client certificates
+func newHTTPClient(roots *x509.CertPool, certs []tls.Certificate, insecure bool) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            roots,
+		Certificates:       certs,
+		InsecureSkipVerify: insecure,
+	}
+
+	return &http.Client{Transport: tr, Timeout: clientTimeout}
+}
+
+// loadRootCAs returns the system CAs extended by the CAs of the bundle at
+// path, or nil if no bundle is given
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+
+	return pool, nil
+}
+
+// newHTTPClients builds the clients of all targets. Certificates are
+// verified unless verification is disabled for a target.
+func (s *server) newHTTPClients() (*httpClients, error) {
+	roots, err := loadRootCAs(s.param.proxy.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	j := s.param.jenkins
+
+	var certs []tls.Certificate
+	if j.ClientCert != "" || j.ClientKey != "" {
+		if j.ClientCert == "" || j.ClientKey == "" {
+			return nil, errors.New("jenkins-client-cert and jenkins-client-key have to be set both")
+		}
+
+		cert, err := tls.LoadX509KeyPair(j.ClientCert, j.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		certs = []tls.Certificate{cert}
+	}
+
+	if j.Insecure {
+		log.Println("WARNING: certificates of jenkins are not verified")
+	}
+	if s.param.proxy.MappingInsecure {
+		log.Println("WARNING: certificates of the mapping url are not verified")
+	}
+
+	return &httpClients{
+		jenkins: newHTTPClient(roots, certs, j.Insecure),
+		mapping: newHTTPClient(roots, nil, s.param.proxy.MappingInsecure),
+		api:     newHTTPClient(roots, nil, false),
+	}, nil
+}
+
+// jenkinsClient returns the client for requests to jenkins
+func (s *server) jenkinsClient() *http.Client {
+	if s.clients == nil {
+		return defaultHTTPClient
+	}
+
+	return s.clients.jenkins
+}
+
+// apiClient returns the client for requests to the GitLab and GitHub apis
+func (s *server) apiClient() *http.Client {
+	if s.clients == nil {
+		return defaultHTTPClient
+	}
+
+	return s.clients.api
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_server_newHTTPClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	serverCert, serverKey := ca.issue(t, "jenkins", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "proxy", x509.ExtKeyUsageClientAuth)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	writeTestFile(t, bundle, ca.pem, time.Now())
+	writeTestFile(t, filepath.Join(dir, "client.pem"), clientCert, time.Now())
+	writeTestFile(t, filepath.Join(dir, "client-key.pem"), clientKey, time.Now())
+
+	pair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	// jenkins serves a certificate of the private CA and requires client
+	// certificates of it
+	stub := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	stub.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	stub.StartTLS()
+	defer stub.Close()
+
+	// the mapping server uses the self signed certificate of httptest
+	mapping := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer mapping.Close()
+
+	tests := []struct {
+		name           string
+		j              jenkins
+		p              proxy
+		wantErr        bool
+		wantJenkinsErr bool
+		wantMappingErr bool
+	}{
+		{"verify_by_default", jenkins{}, proxy{}, false, true, true},
+		{"ca_bundle_without_client_cert", jenkins{}, proxy{CABundle: bundle}, false, true, true},
+		{"ca_bundle_and_client_cert", jenkins{ClientCert: filepath.Join(dir, "client.pem"), ClientKey: filepath.Join(dir, "client-key.pem")}, proxy{CABundle: bundle}, false, false, true},
+		{"mapping_insecure", jenkins{}, proxy{MappingInsecure: true}, false, true, false},
+		{"cert_without_key", jenkins{ClientCert: filepath.Join(dir, "client.pem")}, proxy{}, true, false, false},
+		{"missing_bundle", jenkins{}, proxy{CABundle: filepath.Join(dir, "missing.pem")}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{param: parameters{jenkins: tt.j, proxy: tt.p}}
+			clients, err := s.newHTTPClients()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newHTTPClients() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			s.clients = clients
+
+			if _, err := httpGetWrapper(s.jenkinsClient(), stub.URL); (err != nil) != tt.wantJenkinsErr {
+				t.Errorf("jenkins request error = %v, wantErr %v", err, tt.wantJenkinsErr)
+			}
+			if _, err := httpGetWrapper(clients.mapping, mapping.URL); (err != nil) != tt.wantMappingErr {
+				t.Errorf("mapping request error = %v, wantErr %v", err, tt.wantMappingErr)
+			}
+		})
+	}
+}
+
+func Test_loadRootCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty := filepath.Join(dir, "empty.pem")
+	writeTestFile(t, empty, pem.EncodeToMemory(&pem.Block{Type: "NOTHING", Bytes: []byte("x")}), time.Now())
+
+	if pool, err := loadRootCAs(""); pool != nil || err != nil {
+		t.Errorf("loadRootCAs() without bundle = %v, %v", pool, err)
+	}
+	if _, err := loadRootCAs(empty); err == nil {
+		t.Error("loadRootCAs() accepted a bundle without certificates")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	cloudEventsContentType      = "application/cloudevents+json"
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+	defCloudEventType           = "com.example.git.push" // default type of accepted cloud events
+)
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// cloudEvent holds the context attributes of a cloud event and its data
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	DataBase64      string          `json:"data_base64"`
+}
+
+// cloudEventData is the data of a repository change event
+type cloudEventData struct {
+	hookEvent
+	Ref string `json:"ref"`
+}
+
+// parseCloudEvent parses a cloud event sent in structured or binary content mode
+func parseCloudEvent(r *http.Request) (cloudEvent, error) {
+	var ce cloudEvent
+
+	l := loggerFrom(r.Context())
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return ce, err
+	}
+
+	switch {
+	case mediaType == cloudEventsContentType:
+		l.info("parsing structured cloud event")
+
+		if err := json.Unmarshal(body, &ce); err != nil {
+			return ce, errors.New("bad request")
+		}
+
+		if ce.DataBase64 != "" {
+			data, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+			if err != nil {
+				return ce, errors.New("invalid data_base64")
+			}
+			ce.Data = data
+		}
+	case mediaType == cloudEventsBatchContentType:
+		return ce, errUnsupportedMediaType
+	case r.Header.Get("Ce-Specversion") != "":
+		l.info("parsing binary cloud event")
+
+		ce.SpecVersion = r.Header.Get("Ce-Specversion")
+		ce.Type = r.Header.Get("Ce-Type")
+		ce.Source = r.Header.Get("Ce-Source")
+		ce.ID = r.Header.Get("Ce-Id")
+		ce.DataContentType = r.Header.Get("Content-Type")
+		ce.Data = body
+	default:
+		return ce, errors.New("no cloud event")
+	}
+
+	if ce.SpecVersion == "" || ce.Type == "" || ce.Source == "" || ce.ID == "" {
+		return ce, errors.New("required cloud event attribute is missing")
+	}
+
+	if !strings.HasPrefix(ce.SpecVersion, "1.") {
+		return ce, errors.New("unsupported cloud event spec version: " + ce.SpecVersion)
+	}
+
+	if ce.DataContentType != "" {
+		dataType, _, _ := mime.ParseMediaType(ce.DataContentType)
+		if dataType != "application/json" && !strings.HasSuffix(dataType, "+json") {
+			return ce, errUnsupportedMediaType
+		}
+	}
+
+	l.info("parsed cloud event", "id", ce.ID, "type", ce.Type, "source", ce.Source)
+
+	return ce, nil
+}
+
+// event returns the repository change carried in the data of the cloud event
+func (ce cloudEvent) event(ctx context.Context) (hookEvent, error) {
+	var data cloudEventData
+
+	if err := json.Unmarshal(ce.Data, &data); err != nil {
+		return data.hookEvent, errors.New("invalid cloud event data")
+	}
+
+	ev := data.hookEvent
+	if ev.Repo == "" {
+		return ev, errors.New("repo is missing")
+	}
+
+	if ev.Branch == "" {
+		ev.Branch = strings.TrimPrefix(data.Ref, "refs/heads/")
+	}
+
+	if ev.Branch == "" {
+		loggerFrom(ctx).info("branch is missing, assuming master")
+
+		ev.Branch = "master"
+	}
+
+	ev.Files = uniqueNonEmptyElementsOf(ev.Files)
+
+	sort.Strings(ev.Files)
+
+	return ev, nil
+}
+
+func (s *server) handleCloudEvent() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// abuse protection handshake of the cloud events webhook spec
+		if r.Method == http.MethodOptions {
+			if origin := r.Header.Get("WebHook-Request-Origin"); origin != "" {
+				w.Header().Set("WebHook-Allowed-Origin", origin)
+			}
+			w.Header().Set("Allow", "POST, OPTIONS")
+			w.WriteHeader(http.StatusOK)
+
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST, OPTIONS")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		ctx := r.Context()
+		loggerFrom(ctx).info("handling new cloud event")
+
+		ce, err := parseCloudEvent(r)
+		if err == errUnsupportedMediaType {
+			loggerFrom(ctx).warn("aborting request handling", "error", err)
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+
+			return
+		} else if err != nil {
+			metrics.inc(metricParseFailures, "cloudevents")
+			writeBadRequest(ctx, w, err)
+
+			return
+		}
+
+		if !acceptAction(s.param.proxy.CloudEventTypes, ce.Type) {
+			writeBadRequest(ctx, w, errors.New("unsupported cloud event type: "+ce.Type))
+
+			return
+		}
+
+		ev, err := ce.event(ctx)
+		if err != nil {
+			metrics.inc(metricParseFailures, "cloudevents")
+			writeBadRequest(ctx, w, err)
+
+			return
+		}
+
+		ctx = withAuditEvent(ctx, newAuditEvent(r, "cloudevents", ev.Before, ev.After))
+		resp := newTriggerResponse()
+		jobs, err := s.processRevisions(ctx, ev.Repo, ev.Branch, ev.Files, ev.Before, ev.After, nil)
+		resp.add(ev.Repo, ev.Branch, jobs, err)
+
+		writeTriggerResponse(w, resp)
+
+		loggerFrom(ctx).info("handling of cloud event finished")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_server_handleCloudEvent(t *testing.T) {
+	data := `{"repo": "git://repo/magic/repo", "ref": "refs/heads/master", "files": ["sub/file"]}`
+	structured := `{
+		"specversion": "1.0",
+		"type": "com.example.git.push",
+		"source": "/git/server",
+		"id": "A234-1234-1234",
+		"datacontenttype": "application/json",
+		"data": ` + data + `
+	  }`
+	tests := []struct {
+		name     string
+		method   string
+		header   map[string]string
+		body     string
+		wantHTTP int
+		wantHits int
+	}{
+		{
+			"structured",
+			"POST",
+			map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"},
+			structured,
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"structured_base64",
+			"POST",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion": "1.0", "type": "com.example.git.push", "source": "/git", "id": "1",
+			  "data_base64": "eyJyZXBvIjogImdpdDovL3JlcG8vbWFnaWMvcmVwbyIsICJicmFuY2giOiAibWFzdGVyIiwgImZpbGVzIjogWyJzdWIvZmlsZSJdfQ=="}`,
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"binary",
+			"POST",
+			map[string]string{
+				"Content-Type":   "application/json",
+				"Ce-Specversion": "1.0",
+				"Ce-Type":        "com.example.git.push",
+				"Ce-Source":      "/git/server",
+				"Ce-Id":          "A234-1234-1234",
+			},
+			data,
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"binary_nomatch",
+			"POST",
+			map[string]string{
+				"Content-Type":   "application/json",
+				"Ce-Specversion": "1.0",
+				"Ce-Type":        "com.example.git.push",
+				"Ce-Source":      "/git/server",
+				"Ce-Id":          "A234-1234-1234",
+			},
+			`{"repo": "git://repo/magic/other", "branch": "master"}`,
+			http.StatusOK,
+			0,
+		},
+		{
+			"binary_xml",
+			"POST",
+			map[string]string{
+				"Content-Type":   "application/xml",
+				"Ce-Specversion": "1.0",
+				"Ce-Type":        "com.example.git.push",
+				"Ce-Source":      "/git/server",
+				"Ce-Id":          "A234-1234-1234",
+			},
+			`<repo/>`,
+			http.StatusUnsupportedMediaType,
+			0,
+		},
+		{
+			"batch",
+			"POST",
+			map[string]string{"Content-Type": "application/cloudevents-batch+json"},
+			`[` + structured + `]`,
+			http.StatusUnsupportedMediaType,
+			0,
+		},
+		{
+			"missing_id",
+			"POST",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			`{"specversion": "1.0", "type": "com.example.git.push", "source": "/git", "data": ` + data + `}`,
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"unknown_type",
+			"POST",
+			map[string]string{"Content-Type": "application/cloudevents+json"},
+			strings.Replace(structured, "com.example.git.push", "com.example.git.tag", 1),
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"no_cloud_event",
+			"POST",
+			map[string]string{"Content-Type": "application/json"},
+			data,
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"get",
+			"GET",
+			nil,
+			"",
+			http.StatusMethodNotAllowed,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				mapping:        map[string][]string{"git://repo/magic/repo|master|sub": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param: parameters{proxy: proxy{
+					QuietPeriod:     5,
+					FileMatching:    true,
+					CloudEventTypes: []string{defCloudEventType},
+				}},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/cloudevents", strings.NewReader(tt.body))
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			http.HandlerFunc(s.handleCloudEvent()).ServeHTTP(w, r)
+			if status := w.Result().StatusCode; status != tt.wantHTTP {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantHTTP)
+			}
+			if hits := len(s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			for _, pj := range s.timeKeeper {
+				pj.timer.Stop()
+			}
+		})
+	}
+}
+
+func Test_server_handleCloudEventAbuseProtection(t *testing.T) {
+	s := server{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("OPTIONS", "/cloudevents", nil)
+	r.Header.Set("WebHook-Request-Origin", "eventemitter.example.com")
+	http.HandlerFunc(s.handleCloudEvent()).ServeHTTP(w, r)
+	if status := w.Result().StatusCode; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if origin := w.Result().Header.Get("WebHook-Allowed-Origin"); origin != "eventemitter.example.com" {
+		t.Errorf("handler returned wrong allowed origin: got %v", origin)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxDeliveryBody     = 1 << 20  // bodies of larger requests are not kept
+	maxDeliveryResponse = 64 << 10 // responses are cut after this size
+)
+
+var errUnknownDelivery = errors.New("unknown delivery")
+
+// delivery is a received webhook and the answer of the proxy
+type delivery struct {
+	ID        string      `json:"id"`
+	Time      time.Time   `json:"time"`
+	RequestID string      `json:"request_id,omitempty"`
+	Endpoint  string      `json:"endpoint"`
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+	Status    int         `json:"status"`
+	Response  string      `json:"response,omitempty"`
+	ReplayOf  string      `json:"replay_of,omitempty"`
+}
+
+// deliveryLog is a ring of the last received deliveries, optionally
+// persisted to a file. The file is written in the background, so webhooks
+// don't wait for it.
+type deliveryLog struct {
+	mu       sync.Mutex
+	path     string
+	size     int
+	seq      int
+	entries  []delivery
+	handlers map[string]http.HandlerFunc
+
+	// changed wakes the saver, changes made while it writes are saved
+	// together by its next write
+	changed chan struct{}
+	saveMu  sync.Mutex
+}
+
+func newDeliveryLog(size int, path string) (*deliveryLog, error) {
+	d := &deliveryLog{
+		path:     path,
+		size:     size,
+		entries:  []delivery{},
+		handlers: make(map[string]http.HandlerFunc),
+		changed:  make(chan struct{}, 1),
+	}
+
+	if path == "" {
+		return d, nil
+	}
+
+	go d.saveChanges()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return d, err
+	}
+
+	if err := json.Unmarshal(data, &d.entries); err != nil {
+		return d, err
+	}
+
+	if len(d.entries) > size {
+		d.entries = d.entries[len(d.entries)-size:]
+	}
+	for _, e := range d.entries {
+		if id, err := strconv.Atoi(e.ID); err == nil && id > d.seq {
+			d.seq = id
+		}
+	}
+
+	defaultLogger.info("loaded deliveries", "count", len(d.entries), "path", path)
+
+	return d, nil
+}
+
+// add stores e as newest delivery, dropping the oldest if the ring is full,
+// and returns it with its id
+func (d *deliveryLog) add(e delivery) delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.seq++
+	e.ID = strconv.Itoa(d.seq)
+
+	d.entries = append(d.entries, e)
+	if len(d.entries) > d.size {
+		d.entries = append([]delivery{}, d.entries[len(d.entries)-d.size:]...)
+	}
+
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+
+	return e
+}
+
+// saveChanges writes the deliveries to disk whenever they changed
+func (d *deliveryLog) saveChanges() {
+	for range d.changed {
+		if err := d.save(); err != nil {
+			defaultLogger.error("saving deliveries failed", "error", err)
+		}
+	}
+}
+
+// save writes the current deliveries without secrets to disk, so replays
+// of loaded deliveries lack their credentials
+func (d *deliveryLog) save() error {
+	if d == nil || d.path == "" {
+		return nil
+	}
+
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+
+	d.mu.Lock()
+	entries := make([]delivery, 0, len(d.entries))
+	for _, e := range d.entries {
+		entries = append(entries, e.redacted())
+	}
+	d.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := d.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, d.path)
+}
+
+// get returns the delivery with the given id
+func (d *deliveryLog) get(id string) (delivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, e := range d.entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+
+	return delivery{}, false
+}
+
+// list returns the deliveries, newest first, without secrets
+func (d *deliveryLog) list() []delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := make([]delivery, 0, len(d.entries))
+	for i := len(d.entries) - 1; i >= 0; i-- {
+		list = append(list, d.entries[i].redacted())
+	}
+
+	return list
+}
+
+// redacted returns e without the credentials in its headers and url
+func (e delivery) redacted() delivery {
+	e.Header = redactHeader(e.Header)
+	e.URL = redactURL(e.URL)
+
+	return e
+}
+
+// isSecret reports whether a header or query parameter carries credentials
+func isSecret(name string) bool {
+	lower := strings.ToLower(name)
+
+	return lower == "authorization" || strings.Contains(lower, "token") || strings.Contains(lower, "signature") ||
+		strings.Contains(lower, "secret") || strings.Contains(lower, "password")
+}
+
+// redactHeader returns a copy of header without the values of headers
+// which carry credentials
+func redactHeader(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		if isSecret(key) {
+			values = []string{"<redacted>"}
+		}
+		redacted[key] = values
+	}
+
+	return redacted
+}
+
+// redactURL returns the request uri without the values of query parameters
+// which carry credentials
+func redactURL(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return strings.SplitN(uri, "?", 2)[0]
+	}
+
+	query := u.Query()
+	changed := false
+	for key := range query {
+		if isSecret(key) {
+			query[key] = []string{"<redacted>"}
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.RequestURI()
+}
+
+// deliveryRecorder keeps the status and the start of the body written by
+// a handler
+type deliveryRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *deliveryRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *deliveryRecorder) Write(p []byte) (int, error) {
+	if room := maxDeliveryResponse - r.body.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		r.body.Write(p[:room])
+	}
+
+	return r.ResponseWriter.Write(p)
+}
+
+// replayWriter discards the answer to a replayed delivery, which is kept
+// by the delivery log instead
+type replayWriter struct {
+	header http.Header
+}
+
+func (w *replayWriter) Header() http.Header         { return w.header }
+func (w *replayWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *replayWriter) WriteHeader(int)             {}
+
+// replay is handed to a replayed request to receive its delivery
+type replay struct {
+	of     string
+	result delivery
+}
+
+type replayKey struct{}
+
+// recordDelivery stores every request handled by h in the delivery log.
+// Without a delivery log h is returned unchanged.
+func (s *server) recordDelivery(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	if s.deliveries == nil {
+		return h
+	}
+
+	recorded := func(w http.ResponseWriter, r *http.Request) {
+		e := delivery{
+			Time:      time.Now(),
+			RequestID: requestIDFrom(r.Context()),
+			Endpoint:  endpoint,
+			Method:    r.Method,
+			URL:       r.URL.RequestURI(),
+			Header:    r.Header.Clone(),
+		}
+		rp, _ := r.Context().Value(replayKey{}).(*replay)
+		if rp != nil {
+			e.ReplayOf = rp.of
+		}
+
+		body, _ := ioutil.ReadAll(io.LimitReader(r.Body, maxDeliveryBody+1))
+		if len(body) > maxDeliveryBody {
+			e.Truncated = true
+		} else {
+			e.Body = string(body)
+		}
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+		rec := &deliveryRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+
+		e.Status = rec.status
+		e.Response = rec.body.String()
+		e = s.deliveries.add(e)
+
+		if rp != nil {
+			rp.result = e
+		}
+	}
+
+	s.deliveries.mu.Lock()
+	s.deliveries.handlers[endpoint] = recorded
+	s.deliveries.mu.Unlock()
+
+	return recorded
+}
+
+// replayDelivery handles the stored delivery with the given id again and
+// returns the new delivery
+func (s *server) replayDelivery(id string) (delivery, error) {
+	e, ok := s.deliveries.get(id)
+	if !ok {
+		return delivery{}, errUnknownDelivery
+	}
+	if e.Truncated {
+		return delivery{}, errors.New("body of delivery " + id + " was not kept")
+	}
+
+	s.deliveries.mu.Lock()
+	h, ok := s.deliveries.handlers[e.Endpoint]
+	s.deliveries.mu.Unlock()
+	if !ok {
+		return delivery{}, errors.New("no handler for endpoint " + e.Endpoint)
+	}
+
+	r, err := http.NewRequest(e.Method, e.URL, strings.NewReader(e.Body))
+	if err != nil {
+		return delivery{}, err
+	}
+	r.RequestURI = e.URL
+	r.RemoteAddr = "replay"
+	r.Header = e.Header.Clone()
+	r.Header.Del("X-Request-ID")
+
+	rp := &replay{of: id}
+	r = r.WithContext(context.WithValue(r.Context(), replayKey{}, rp))
+
+	defaultLogger.info("replaying delivery", "delivery", id, "endpoint", e.Endpoint)
+
+	instrument(e.Endpoint, h)(&replayWriter{header: make(http.Header)}, r)
+
+	return rp.result, nil
+}
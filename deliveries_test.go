@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_deliveryLog_add(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "deliveries.json")
+	d, err := newDeliveryLog(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, endpoint := range []string{"/", "/json", "/event"} {
+		d.add(delivery{Endpoint: endpoint, URL: endpoint + "?repo=r&token=secret", Header: http.Header{"X-Gitlab-Token": {"secret"}}})
+	}
+
+	list := d.list()
+	if len(list) != 2 || list[0].ID != "3" || list[1].ID != "2" {
+		t.Fatalf("list() = %+v", list)
+	}
+	if got := list[0].Header.Get("X-Gitlab-Token"); got != "<redacted>" {
+		t.Errorf("token header listed as %q", got)
+	}
+	if got := list[0].URL; got != "/event?repo=r&token=%3Credacted%3E" {
+		t.Errorf("url listed as %q", got)
+	}
+	if e, _ := d.get("3"); e.Header.Get("X-Gitlab-Token") != "secret" {
+		t.Error("stored delivery lost its token header")
+	}
+
+	// the file is written in the background, save waits for it
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("saved deliveries contain the secret: %s", data)
+	}
+
+	loaded, err := newDeliveryLog(2, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := loaded.add(delivery{Endpoint: "/"}); e.ID != "4" {
+		t.Errorf("reloaded delivery log continues with id %s, want 4", e.ID)
+	}
+}
+
+func Test_server_replayDelivery(t *testing.T) {
+	deliveries, err := newDeliveryLog(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{
+		mapping:        map[string][]string{"repo|master": {"old"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		deliveries:     deliveries,
+		param:          parameters{proxy: proxy{QuietPeriod: 60, AdminToken: "secret"}},
+	}
+	defer s.cancelJobs()
+
+	handler := instrument("/event", s.recordDelivery("/event", s.handleEvent()))
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/event", strings.NewReader(`{"repo":"repo","branch":"develop"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delivery answered with %v", w.Code)
+	}
+
+	s.mapping = map[string][]string{"repo|develop": {"new"}}
+
+	w = adminRequest(s, "POST", "/admin/deliveries/replay?id=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("replay answered with %v: %s", w.Code, w.Body.String())
+	}
+
+	var replayed delivery
+	if err := json.Unmarshal(w.Body.Bytes(), &replayed); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != "2" || replayed.ReplayOf != "1" || replayed.Status != http.StatusAccepted {
+		t.Errorf("replayed delivery = %+v", replayed)
+	}
+	if !s.isPending("new") {
+		t.Error("replay didn't schedule the job of the fixed mapping")
+	}
+
+	if w := adminRequest(s, "POST", "/admin/deliveries/replay?id=9"); w.Code != http.StatusNotFound {
+		t.Errorf("replay of unknown delivery answered with %v", w.Code)
+	}
+
+	w = adminRequest(s, "GET", "/admin/deliveries")
+	var list []delivery
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].Body != `{"repo":"repo","branch":"develop"}` {
+		t.Errorf("deliveries = %+v", list)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// keyExplanation describes which mapping key matched a computed key
+type keyExplanation struct {
+	Key   string   `json:"key"`
+	File  string   `json:"file,omitempty"`
+	Match string   `json:"match,omitempty"`
+	Jobs  []string `json:"jobs,omitempty"`
+}
+
+// jobExplanation describes how a matched job would be scheduled
+type jobExplanation struct {
+	Job         string     `json:"job"`
+	QuietPeriod int        `json:"quiet_period"`
+	Pending     bool       `json:"pending"`
+	Params      url.Values `json:"params,omitempty"`
+}
+
+// explanation describes how an event for a repo resolves to jobs
+type explanation struct {
+	Repo     string           `json:"repo"`
+	Branch   string           `json:"branch"`
+	Files    []string         `json:"files,omitempty"`
+	AllFiles bool             `json:"all_files,omitempty"`
+	Keys     []keyExplanation `json:"keys"`
+	Jobs     []jobExplanation `json:"jobs"`
+	Skipped  string           `json:"skipped,omitempty"`
+}
+
+// explainKeys looks up keys like matchMappingKeys without scheduling
+// anything. With file matching the files belong to the keys of the same
+// index.
+func (s *server) explainKeys(e *explanation, keys, files []string, filematch bool, params url.Values) {
+	e.Keys = []keyExplanation{}
+	e.Jobs = []jobExplanation{}
+
+	var jobs []string
+	for i, key := range keys {
+		ke := keyExplanation{Key: key}
+		if filematch && len(files) == len(keys) {
+			ke.File = files[i]
+		}
+
+		ke.Match, ke.Jobs = s.lookupMappingKey(key, filematch)
+		jobs = append(jobs, ke.Jobs...)
+
+		e.Keys = append(e.Keys, ke)
+	}
+
+	for _, job := range uniqueNonEmptyElementsOf(jobs) {
+		pending := s.isPending(job)
+
+		e.Jobs = append(e.Jobs, jobExplanation{
+			Job:         job,
+			QuietPeriod: s.param.proxy.QuietPeriod,
+			Pending:     pending,
+			Params:      params,
+		})
+	}
+}
+
+// explainPush explains the matching of a push to repo and branch
+func (s *server) explainPush(repo, branch string, files []string, allFiles bool) explanation {
+	e := explanation{Repo: repo, Branch: branch, Files: files, AllFiles: allFiles}
+
+	if allFiles {
+		s.explainKeys(&e, s.branchKeys(repo, branch), nil, false, nil)
+	} else {
+		keys := evalMappingKeys(repo, branch, files, s.param.proxy.FileMatching, s.param.proxy.SemanticRepo)
+		s.explainKeys(&e, keys, files, s.param.proxy.FileMatching, nil)
+	}
+
+	return e
+}
+
+// explainChangeRequest explains the matching of a merge or pull request
+func (s *server) explainChangeRequest(section, repo, target, source string, params url.Values, skipped string) explanation {
+	e := explanation{Repo: repo, Branch: target}
+
+	s.explainKeys(&e, changeRequestKeys(section, repo, target, source), nil, false, params)
+
+	if skipped != "" {
+		e.Skipped = skipped
+		e.Jobs = []jobExplanation{}
+	}
+
+	return e
+}
+
+// explainGet explains a request to the plain get endpoint
+func (s *server) explainGet(r *http.Request) ([]explanation, error) {
+	repo, branch, files, err := parseGetRequest(r, s.param.proxy.FileMatching)
+	if err != nil {
+		return nil, err
+	}
+
+	before, after := parseGetRevisions(r)
+	files, allFiles := s.resolveFiles(r.Context(), repo, files, before, after)
+
+	return []explanation{s.explainPush(repo, branch, files, allFiles)}, nil
+}
+
+// explainJSON explains a request to the json endpoint
+func (s *server) explainJSON(r *http.Request) ([]explanation, error) {
+	explanations := []explanation{}
+
+	if r.Header.Get("X-Gitlab-Event") == "Merge Request Hook" {
+		mr, err := parseMergeRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		skipped := mr.skipReason(s.param.proxy.MRActions)
+
+		for _, repo := range mr.Repos {
+			explanations = append(explanations, s.explainChangeRequest(mergeRequestSection, repo, mr.TargetBranch, mr.SourceBranch, mr.jobParameters(), skipped))
+		}
+
+		return explanations, nil
+	}
+
+	if r.Header.Get("X-GitHub-Event") == "pull_request" {
+		pr, err := parsePullRequest(r)
+		if err != nil {
+			return nil, err
+		}
+
+		skipped := ""
+		if !acceptAction(s.param.proxy.PRActions, pr.Action) {
+			skipped = "ignored pull request action: " + pr.Action
+		} else if pr.Draft && s.param.proxy.PRSkipDraft {
+			skipped = "draft pull request"
+		}
+
+		for _, repo := range pr.Repos {
+			explanations = append(explanations, s.explainChangeRequest(pullRequestSection, repo, pr.BaseBranch, pr.HeadBranch, pr.jobParameters(), skipped))
+		}
+
+		return explanations, nil
+	}
+
+	push, err := parseJSONRequest(r, s.param.proxy.FileMatching)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := ""
+	if s.skipPush(r.Context(), push.Pusher, push.Commits, !push.Truncated) {
+		skipped = "skip marker or ignored author"
+	}
+
+	files, allFiles := s.resolvePushFiles(r.Context(), push)
+
+	for _, repo := range push.Repos {
+		e := s.explainPush(repo, push.Branch, files, allFiles)
+		if skipped != "" {
+			e.Skipped = skipped
+			e.Jobs = []jobExplanation{}
+		}
+		explanations = append(explanations, e)
+	}
+
+	return explanations, nil
+}
+
+// handleExplain answers which jobs a request to "/" (GET) or "/json"
+// (POST) would schedule, without scheduling anything
+func (s *server) handleExplain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		loggerFrom(ctx).info("handling new explain request")
+
+		var (
+			explanations []explanation
+			err          error
+		)
+
+		switch r.Method {
+		case http.MethodGet:
+			explanations, err = s.explainGet(r)
+		case http.MethodPost:
+			explanations, err = s.explainJSON(r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		if err != nil {
+			writeBadRequest(ctx, w, err)
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, explanations)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_server_handleExplain(t *testing.T) {
+	s := server{
+		mapping: map[string][]string{
+			"git://repo/magic/repo|branch|repo/dir":      {"job1"},
+			"git://repo/magic/repo|branch|repo/file":     {"job2"},
+			"merge_request|git://repo/repo.git|master|*": {"mr-job"},
+		},
+		timeKeeper:     map[string]*pendingJob{"job2": {job: "job2"}},
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			proxy: proxy{
+				QuietPeriod:  5,
+				FileMatching: true,
+				SemanticRepo: "git://repo/magic/",
+				MRActions:    []string{"open"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+		want   []explanation
+	}{
+		{
+			"get",
+			httptest.NewRequest("GET", "/explain?repo=git://repo/magic/repo&branch=branch&files=dir/a&files=file&files=other", nil),
+			http.StatusOK,
+			[]explanation{{
+				Repo:   "git://repo/magic/repo",
+				Branch: "branch",
+				Files:  []string{"dir/a", "file", "other"},
+				Keys: []keyExplanation{
+					{Key: "git://repo/magic/repo|branch|repo/dir/a", File: "dir/a", Match: "git://repo/magic/repo|branch|repo/dir", Jobs: []string{"job1"}},
+					{Key: "git://repo/magic/repo|branch|repo/file", File: "file", Match: "git://repo/magic/repo|branch|repo/file", Jobs: []string{"job2"}},
+					{Key: "git://repo/magic/repo|branch|repo/other", File: "other"},
+				},
+				Jobs: []jobExplanation{
+					{Job: "job1", QuietPeriod: 5},
+					{Job: "job2", QuietPeriod: 5, Pending: true},
+				},
+			}},
+		},
+		{
+			"merge_request_skipped",
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/explain", strings.NewReader(`{"object_kind":"merge_request","object_attributes":{"iid":1,"action":"close","source_branch":"feature","target_branch":"master","target":{"git_http_url":"git://repo/repo.git"}}}`))
+				r.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+				return r
+			}(),
+			http.StatusOK,
+			[]explanation{{
+				Repo:   "git://repo/repo.git",
+				Branch: "master",
+				Keys: []keyExplanation{
+					{Key: "merge_request|git://repo/repo.git|master|feature"},
+					{Key: "merge_request|git://repo/repo.git|master|*", Match: "merge_request|git://repo/repo.git|master|*", Jobs: []string{"mr-job"}},
+					{Key: "merge_request|git://repo/repo.git|*|feature"},
+					{Key: "merge_request|git://repo/repo.git|*|*"},
+				},
+				Jobs:    []jobExplanation{},
+				Skipped: "ignored merge request action: close",
+			}},
+		},
+		{
+			"bad_request",
+			httptest.NewRequest("GET", "/explain?branch=branch", nil),
+			http.StatusBadRequest,
+			nil,
+		},
+		{
+			"method_not_allowed",
+			httptest.NewRequest("DELETE", "/explain", nil),
+			http.StatusMethodNotAllowed,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handleExplain().ServeHTTP(w, tt.r)
+
+			if w.Code != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, tt.status)
+			}
+			if w.Code == http.StatusBadRequest {
+				var resp triggerResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+					t.Errorf("bad request answered with %q", w.Body.String())
+				}
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var got []explanation
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handleExplain() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_explainKeys(t *testing.T) {
+	s := server{
+		mapping:        map[string][]string{"repo|branch": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+	}
+
+	tests := []struct {
+		name      string
+		keys      []string
+		files     []string
+		filematch bool
+		want      []keyExplanation
+	}{
+		{
+			"filematch",
+			[]string{"repo|branch|file"},
+			[]string{"file"},
+			true,
+			[]keyExplanation{{Key: "repo|branch|file", File: "file", Match: "repo|branch", Jobs: []string{"job"}}},
+		},
+		{
+			"no_filematch_single_file",
+			[]string{"repo|branch"},
+			[]string{"file"},
+			false,
+			[]keyExplanation{{Key: "repo|branch", Match: "repo|branch", Jobs: []string{"job"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e explanation
+			s.explainKeys(&e, tt.keys, tt.files, tt.filematch, nil)
+
+			if !reflect.DeepEqual(e.Keys, tt.want) {
+				t.Errorf("explainKeys() = %+v, want %+v", e.Keys, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// genericHook describes how to extract an event from the payload of a
+// webhook sent to /hook/<name>
+type genericHook struct {
+	Repos     []string       `json:"repos"`
+	Ref       string         `json:"ref"`
+	Before    string         `json:"before"`
+	Commit    string         `json:"commit"`
+	Files     []string       `json:"files"`
+	Signature *hookSignature `json:"signature"`
+}
+
+// hookSignature describes the header used to authenticate a generic hook.
+// Type "token" compares the header with the secret, "hmac-sha256" and
+// "hmac-sha1" compare it with the hex encoded hmac of the body.
+type hookSignature struct {
+	Header string `json:"header"`
+	Type   string `json:"type"`
+	Secret string `json:"secret"`
+	Prefix string `json:"prefix"`
+}
+
+// loadGenericHooks reads the generic hook configuration from path
+func loadGenericHooks(path string) (map[string]genericHook, error) {
+	hooks := make(map[string]genericHook)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return hooks, err
+	}
+
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return hooks, err
+	}
+
+	for name, hook := range hooks {
+		if err := hook.validate(); err != nil {
+			return hooks, errors.New("generic hook " + name + ": " + err.Error())
+		}
+	}
+
+	return hooks, nil
+}
+
+// validate checks that all json paths of the hook can be parsed
+func (h genericHook) validate() error {
+	if len(h.Repos) == 0 {
+		return errors.New("no repo path defined")
+	}
+
+	paths := append(append([]string{}, h.Repos...), h.Files...)
+	for _, path := range []string{h.Ref, h.Before, h.Commit} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		if _, err := parseJSONPath(path); err != nil {
+			return err
+		}
+	}
+
+	if h.Signature != nil {
+		if h.Signature.Header == "" || h.Signature.Secret == "" {
+			return errors.New("signature needs header and secret")
+		}
+		if _, err := h.Signature.newHash(); err != nil && h.Signature.Type != "token" {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sig hookSignature) newHash() (func() hash.Hash, error) {
+	switch sig.Type {
+	case "hmac-sha256", "":
+		return sha256.New, nil
+	case "hmac-sha1":
+		return sha1.New, nil
+	}
+
+	return nil, errors.New("unknown signature type: " + sig.Type)
+}
+
+// verify checks the signature header of a request with the given body
+func (sig hookSignature) verify(header http.Header, body []byte) bool {
+	value := header.Get(sig.Header)
+	if value == "" || !strings.HasPrefix(value, sig.Prefix) {
+		return false
+	}
+	value = strings.TrimPrefix(value, sig.Prefix)
+
+	if sig.Type == "token" {
+		return subtle.ConstantTimeCompare([]byte(value), []byte(sig.Secret)) == 1
+	}
+
+	newHash, err := sig.newHash()
+	if err != nil {
+		return false
+	}
+
+	given, err := hex.DecodeString(value)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(sig.Secret))
+	mac.Write(body)
+
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// extract returns the event described by the hook from a json body
+func (h genericHook) extract(ctx context.Context, body []byte) ([]string, hookEvent, error) {
+	var (
+		repos []string
+		ev    hookEvent
+		doc   interface{}
+	)
+
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return repos, ev, errors.New("bad request")
+	}
+
+	first := func(path string) (string, error) {
+		if path == "" {
+			return "", nil
+		}
+		values, err := jsonPathStrings(doc, path)
+		if err != nil || len(values) == 0 {
+			return "", err
+		}
+		return values[0], nil
+	}
+
+	for _, path := range h.Repos {
+		values, err := jsonPathStrings(doc, path)
+		if err != nil {
+			return repos, ev, err
+		}
+		repos = append(repos, values...)
+	}
+	repos = uniqueNonEmptyElementsOf(repos)
+
+	if len(repos) == 0 {
+		return repos, ev, errors.New("repo is missing")
+	}
+
+	ref, err := first(h.Ref)
+	if err != nil {
+		return repos, ev, err
+	}
+
+	ev.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	if ev.Branch == "" {
+		loggerFrom(ctx).info("branch is missing, assuming master")
+
+		ev.Branch = "master"
+	}
+
+	if ev.Before, err = first(h.Before); err != nil {
+		return repos, ev, err
+	}
+
+	if ev.After, err = first(h.Commit); err != nil {
+		return repos, ev, err
+	}
+
+	files := []string{}
+	for _, path := range h.Files {
+		values, err := jsonPathStrings(doc, path)
+		if err != nil {
+			return repos, ev, err
+		}
+		files = append(files, values...)
+	}
+
+	ev.Files = uniqueNonEmptyElementsOf(files)
+
+	sort.Strings(ev.Files)
+
+	return repos, ev, nil
+}
+
+func (s *server) handleGenericHook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/hook/")
+
+		l := loggerFrom(r.Context())
+		l.info("handling new generic hook", "hook", name)
+
+		hook, ok := s.genericHooks[name]
+		if !ok {
+			l.warn("unknown generic hook", "hook", name)
+			http.NotFound(w, r)
+
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if hook.Signature != nil && !hook.Signature.verify(r.Header, body) {
+			l.warn("aborting request handling", "error", "invalid signature")
+
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		repos, ev, err := hook.extract(r.Context(), body)
+		if err != nil {
+			metrics.inc(metricParseFailures, "generic")
+			writeBadRequest(r.Context(), w, err)
+
+			return
+		}
+
+		ctx := withAuditEvent(r.Context(), newAuditEvent(r, "generic", ev.Before, ev.After))
+		resp := newTriggerResponse()
+		for _, repo := range repos {
+			jobs, err := s.processRevisions(ctx, repo, ev.Branch, ev.Files, ev.Before, ev.After, nil)
+			resp.add(repo, ev.Branch, jobs, err)
+		}
+
+		writeTriggerResponse(w, resp)
+
+		loggerFrom(ctx).info("handling of request finished")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_loadGenericHooks(t *testing.T) {
+	hooks, err := loadGenericHooks("./examples/generic_hooks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hooks["inhouse"]; !ok {
+		t.Errorf("loadGenericHooks() = %v, missing hook inhouse", hooks)
+	}
+}
+
+func Test_server_handleGenericHook(t *testing.T) {
+	hooks, err := loadGenericHooks("./examples/generic_hooks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks["open"] = genericHook{Repos: []string{"$.repo"}, Ref: "$.branch"}
+
+	body := `{
+		"repository": {"clone_urls": ["http://repo/magic/repo.git", "git@repo:magic/repo.git"]},
+		"ref": "refs/heads/master",
+		"old": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+		"new": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		"changes": [{"path": "sub/file"}, {"path": "doc/README.md"}]
+	  }`
+	mac := hmac.New(sha256.New, []byte("changeme"))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		signature string
+		wantHTTP  int
+		wantHits  int
+	}{
+		{"match", "/hook/inhouse", body, signature, http.StatusAccepted, 1},
+		{"bad_signature", "/hook/inhouse", body, "sha256=00", http.StatusUnauthorized, 0},
+		{"missing_signature", "/hook/inhouse", body, "", http.StatusUnauthorized, 0},
+		{"unknown_hook", "/hook/unknown", body, "", http.StatusNotFound, 0},
+		{"no_repo", "/hook/open", `{"branch": "master"}`, "", http.StatusBadRequest, 0},
+		{"no_signature_needed", "/hook/open", `{"repo": "git://repo/other", "branch": "master"}`, "", http.StatusAccepted, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				mapping: map[string][]string{
+					"git@repo:magic/repo.git|master|sub": {"job"},
+					"git://repo/other|master":            {"job"},
+				},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				genericHooks:   hooks,
+				param:          parameters{proxy: proxy{QuietPeriod: 5, FileMatching: tt.path == "/hook/inhouse"}},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			if tt.signature != "" {
+				r.Header.Set("X-Inhouse-Signature", tt.signature)
+			}
+			http.HandlerFunc(s.handleGenericHook()).ServeHTTP(w, r)
+			if status := w.Result().StatusCode; status != tt.wantHTTP {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantHTTP)
+			}
+			if hits := len(s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			for _, pj := range s.timeKeeper {
+				pj.timer.Stop()
+			}
+		})
+	}
+}
+
+func Test_hookSignature_verify(t *testing.T) {
+	sig := hookSignature{Header: "X-Token", Type: "token", Secret: "secret"}
+	if !sig.verify(http.Header{"X-Token": {"secret"}}, nil) {
+		t.Error("verify() rejected the right token")
+	}
+	if sig.verify(http.Header{"X-Token": {"wrong"}}, nil) {
+		t.Error("verify() accepted a wrong token")
+	}
+}
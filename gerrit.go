@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errIgnoredGerritEvent is returned for gerrit events which never trigger jobs
+var errIgnoredGerritEvent = errors.New("ignored gerrit event")
+
+// gerritEvent holds the relevant parts of an event of the gerrit webhooks plugin
+type gerritEvent struct {
+	Type     string
+	Project  string
+	Branch   string
+	Before   string
+	After    string
+	Files    []string
+	Change   int
+	PatchSet int
+	Ref      string
+}
+
+func parseGerritEvent(r *http.Request) (gerritEvent, error) {
+	ev := gerritEvent{Files: []string{}}
+
+	type gerritFile struct {
+		File        string
+		FileOld     string `json:"fileOld"`
+		OldFileName string `json:"oldFileName"`
+	}
+
+	type gerritPatchSet struct {
+		Number   int
+		Revision string
+		Ref      string
+		Files    []gerritFile
+	}
+
+	type gerritChange struct {
+		Project string
+		Branch  string
+		Number  int
+	}
+
+	type gerritRefUpdate struct {
+		OldRev  string
+		NewRev  string
+		RefName string
+		Project string
+	}
+
+	type gerritWebhook struct {
+		Type      string
+		Change    *gerritChange
+		PatchSet  *gerritPatchSet
+		RefUpdate *gerritRefUpdate
+		NewRev    string
+	}
+
+	l := loggerFrom(r.Context())
+
+	l.info("parsing gerrit event")
+
+	var h gerritWebhook
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &h); err != nil {
+		return ev, errors.New("bad request")
+	}
+
+	ev.Type = h.Type
+
+	switch h.Type {
+	case "ref-updated":
+		if h.RefUpdate == nil {
+			return ev, errors.New("ref update is missing")
+		}
+
+		ev.Project = h.RefUpdate.Project
+		ev.Branch = h.RefUpdate.RefName
+		ev.Before = h.RefUpdate.OldRev
+		ev.After = h.RefUpdate.NewRev
+	case "change-merged", "patchset-created":
+		if h.Change == nil || h.PatchSet == nil {
+			return ev, errors.New("change or patch set is missing")
+		}
+
+		ev.Project = h.Change.Project
+		ev.Branch = h.Change.Branch
+		ev.Change = h.Change.Number
+		ev.PatchSet = h.PatchSet.Number
+		ev.Ref = h.PatchSet.Ref
+		ev.After = h.PatchSet.Revision
+		if h.Type == "change-merged" && h.NewRev != "" {
+			ev.After = h.NewRev
+		}
+
+		for _, file := range h.PatchSet.Files {
+			// gerrit lists the commit message and merge parents as magic files
+			if strings.HasPrefix(file.File, "/") {
+				continue
+			}
+			ev.Files = append(ev.Files, file.File, file.FileOld, file.OldFileName)
+		}
+	default:
+		return ev, errIgnoredGerritEvent
+	}
+
+	if ev.Project == "" {
+		return ev, errors.New("project is missing")
+	}
+
+	if strings.HasPrefix(ev.Branch, "refs/") && !strings.HasPrefix(ev.Branch, "refs/heads/") {
+		return ev, errIgnoredGerritEvent
+	}
+	ev.Branch = strings.TrimPrefix(ev.Branch, "refs/heads/")
+
+	ev.Files = uniqueNonEmptyElementsOf(ev.Files)
+
+	sort.Strings(ev.Files)
+
+	l.info("parsed gerrit event", "type", ev.Type, "project", ev.Project, "branch", ev.Branch)
+
+	return ev, nil
+}
+
+// gerritRepoURL returns the clone url of a gerrit project
+func gerritRepoURL(baseURL, project string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + project
+}
+
+// jobParameters returns the parameters handed to jenkins for a patch set
+func (ev gerritEvent) jobParameters() url.Values {
+	if ev.Type != "patchset-created" {
+		return nil
+	}
+
+	return url.Values{
+		"GERRIT_CHANGE_NUMBER":   {strconv.Itoa(ev.Change)},
+		"GERRIT_PATCHSET_NUMBER": {strconv.Itoa(ev.PatchSet)},
+		"GERRIT_REFSPEC":         {ev.Ref},
+		"GERRIT_BRANCH":          {ev.Branch},
+	}
+}
+
+func (s *server) handleGerrit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		loggerFrom(ctx).info("handling new gerrit event")
+
+		if s.param.gerrit.URL == "" {
+			loggerFrom(ctx).warn("no gerrit url defined")
+			http.NotFound(w, r)
+
+			return
+		}
+
+		resp := newTriggerResponse()
+
+		ev, err := parseGerritEvent(r)
+		if err == errIgnoredGerritEvent {
+			loggerFrom(ctx).info("ignoring gerrit event", "type", ev.Type)
+
+			resp.Skipped = "ignored gerrit event: " + ev.Type
+			writeTriggerResponse(w, resp)
+
+			return
+		} else if err != nil {
+			metrics.inc(metricParseFailures, "gerrit")
+			writeBadRequest(ctx, w, err)
+
+			return
+		}
+
+		repo := gerritRepoURL(s.param.gerrit.URL, ev.Project)
+
+		ctx = withAuditEvent(ctx, newAuditEvent(r, "gerrit", ev.Before, ev.After))
+		process := s.processRevisions
+		if ev.Type == "ref-updated" {
+			process = s.processRefUpdate
+		}
+		jobs, err := process(ctx, repo, ev.Branch, ev.Files, ev.Before, ev.After, ev.jobParameters())
+		resp.add(repo, ev.Branch, jobs, err)
+
+		writeTriggerResponse(w, resp)
+
+		loggerFrom(ctx).info("handling of request finished")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const gerritPatchSetCreated = `{
+	"type": "patchset-created",
+	"uploader": {"name": "Jane Doe", "email": "jane@example.com"},
+	"patchSet": {
+	  "number": 2,
+	  "revision": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+	  "ref": "refs/changes/34/1234/2",
+	  "files": [
+		{"file": "/COMMIT_MSG", "type": "ADDED"},
+		{"file": "sub/new", "fileOld": "sub/old", "type": "RENAMED"},
+		{"file": "doc/README.md", "type": "MODIFIED"}
+	  ]
+	},
+	"change": {
+	  "project": "platform/build",
+	  "branch": "master",
+	  "id": "I0123456789abcdef",
+	  "number": 1234
+	},
+	"project": "platform/build",
+	"refName": "refs/heads/master"
+  }`
+
+func Test_parseGerritEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    gerritEvent
+		wantErr bool
+	}{
+		{
+			"patchset_created",
+			gerritPatchSetCreated,
+			gerritEvent{
+				Type:     "patchset-created",
+				Project:  "platform/build",
+				Branch:   "master",
+				After:    "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				Files:    []string{"doc/README.md", "sub/new", "sub/old"},
+				Change:   1234,
+				PatchSet: 2,
+				Ref:      "refs/changes/34/1234/2",
+			},
+			false,
+		},
+		{
+			"change_merged",
+			`{"type": "change-merged", "newRev": "b2",
+			  "change": {"project": "platform/build", "branch": "devel", "number": 7},
+			  "patchSet": {"number": 1, "revision": "a1", "ref": "refs/changes/07/7/1"}}`,
+			gerritEvent{
+				Type:     "change-merged",
+				Project:  "platform/build",
+				Branch:   "devel",
+				After:    "b2",
+				Files:    []string{},
+				Change:   7,
+				PatchSet: 1,
+				Ref:      "refs/changes/07/7/1",
+			},
+			false,
+		},
+		{
+			"ref_updated",
+			`{"type": "ref-updated", "refUpdate": {"oldRev": "a1", "newRev": "b2", "refName": "refs/heads/master", "project": "platform/build"}}`,
+			gerritEvent{
+				Type:    "ref-updated",
+				Project: "platform/build",
+				Branch:  "master",
+				Before:  "a1",
+				After:   "b2",
+				Files:   []string{},
+			},
+			false,
+		},
+		{
+			"ref_updated_tag",
+			`{"type": "ref-updated", "refUpdate": {"oldRev": "a1", "newRev": "b2", "refName": "refs/tags/v1", "project": "platform/build"}}`,
+			gerritEvent{},
+			true,
+		},
+		{
+			"unsupported",
+			`{"type": "comment-added"}`,
+			gerritEvent{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "/gerrit", strings.NewReader(tt.body))
+			got, err := parseGerritEvent(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseGerritEvent() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGerritEvent() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_handleGerrit(t *testing.T) {
+	s := server{
+		mapping:        map[string][]string{"https://gerrit.example.com/platform/build|master|sub": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			gerrit: gerrit{URL: "https://gerrit.example.com/"},
+			proxy:  proxy{QuietPeriod: 5, FileMatching: true},
+		},
+	}
+	w := httptest.NewRecorder()
+	http.HandlerFunc(s.handleGerrit()).ServeHTTP(w, httptest.NewRequest("POST", "/gerrit", strings.NewReader(`{"type": "comment-added"}`)))
+	if status := w.Result().StatusCode; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code for ignored event: got %v want %v", status, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	http.HandlerFunc(s.handleGerrit()).ServeHTTP(w, httptest.NewRequest("POST", "/gerrit", strings.NewReader(gerritPatchSetCreated)))
+	if status := w.Result().StatusCode; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+	pj, ok := s.timeKeeper["job|gerrit.example.com/platform/build|GERRIT_CHANGE_NUMBER=1234"]
+	if !ok {
+		t.Fatalf("handler did not schedule job: %v", s.timeKeeper)
+	}
+	pj.timer.Stop()
+	if got := pj.params.Get("GERRIT_REFSPEC"); got != "refs/changes/34/1234/2" {
+		t.Errorf("timer has wrong GERRIT_REFSPEC parameter: got %v", got)
+	}
+}
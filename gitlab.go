@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// emptyCommit is the SHA GitLab sends as "before" for newly created branches
+const emptyCommit = "0000000000000000000000000000000000000000"
+
+// gitlabAPIURL returns the API base URL of the configured GitLab instance
+//
+// The URL is never derived from a webhook, as anyone able to reach the
+// proxy could send one pointing to a host collecting the token.
+func (s *server) gitlabAPIURL() (string, error) {
+	if s.param.gitlab.URL == "" {
+		return "", errors.New("no gitlab url defined")
+	}
+
+	return strings.TrimSuffix(s.param.gitlab.URL, "/") + "/api/v4", nil
+}
+
+// gitlabCompareFiles asks the GitLab compare API for all files changed
+// between the before and after commit of a push
+func (s *server) gitlabCompareFiles(ctx context.Context, push pushEvent) ([]string, error) {
+	files := []string{}
+
+	if s.param.gitlab.Token == "" {
+		return files, errors.New("no gitlab token defined")
+	}
+
+	if push.ProjectID == 0 || push.Before == "" || push.After == "" || push.Before == emptyCommit {
+		return files, errors.New("push can not be compared")
+	}
+
+	apiURL, err := s.gitlabAPIURL()
+	if err != nil {
+		return files, err
+	}
+
+	query := url.Values{}
+	query.Set("from", push.Before)
+	query.Set("to", push.After)
+
+	compareURL := apiURL + "/projects/" + strconv.Itoa(push.ProjectID) + "/repository/compare?" + query.Encode()
+
+	l := loggerFrom(ctx)
+	l.info("requesting changed files from gitlab", "url", compareURL)
+
+	body, err := httpGetWithHeader(s.apiClient(), compareURL, http.Header{"Private-Token": {s.param.gitlab.Token}})
+	if err != nil {
+		return files, err
+	}
+
+	type gitlabDiff struct {
+		OldPath string `json:"old_path"`
+		NewPath string `json:"new_path"`
+	}
+
+	type gitlabCompare struct {
+		Diffs []gitlabDiff
+	}
+
+	var c gitlabCompare
+	if err := json.Unmarshal(body, &c); err != nil {
+		return files, err
+	}
+
+	for _, diff := range c.Diffs {
+		files = append(files, diff.OldPath, diff.NewPath)
+	}
+
+	files = uniqueNonEmptyElementsOf(files)
+
+	sort.Strings(files)
+
+	l.info("gitlab reported changed files", "files", len(files))
+
+	return files, nil
+}
+
+// resolvePushFiles completes the files of a truncated push with the GitLab
+// compare API, if file matching is enabled. It reports whether all mappings
+// of the branch have to be used instead.
+func (s *server) resolvePushFiles(ctx context.Context, push pushEvent) ([]string, bool) {
+	if !s.param.proxy.FileMatching || !push.Truncated {
+		return push.Files, false
+	}
+
+	l := loggerFrom(ctx)
+	l.info("push event is truncated, asking gitlab for changed files")
+
+	files, err := s.gitlabCompareFiles(ctx, push)
+	if err != nil {
+		l.warn("falling back to all mappings of the branch", "error", err)
+
+		return push.Files, true
+	}
+
+	return files, false
+}
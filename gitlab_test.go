@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newGitlabCompareStub returns a stub of the gitlab compare api for project 15
+func newGitlabCompareStub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Private-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v4/projects/15/repository/compare" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("from") != "before" || r.URL.Query().Get("to") != "after" {
+			t.Errorf("compare called with wrong range: %v", r.URL.RawQuery)
+		}
+		io.WriteString(w, `{
+			"commits": [],
+			"diffs": [
+			  {"old_path": "old/file", "new_path": "new/file", "renamed_file": true},
+			  {"old_path": "sub/a", "new_path": "sub/a"}
+			]
+		  }`)
+	}))
+}
+
+func Test_server_gitlabAPIURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		gitlab  gitlab
+		want    string
+		wantErr bool
+	}{
+		{
+			"configured",
+			gitlab{URL: "https://gitlab.example.com/"},
+			"https://gitlab.example.com/api/v4",
+			false,
+		},
+		{
+			"not_configured",
+			gitlab{},
+			"",
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{param: parameters{gitlab: tt.gitlab}}
+			got, err := s.gitlabAPIURL()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gitlabAPIURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("gitlabAPIURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_gitlabCompareFiles(t *testing.T) {
+	stub := newGitlabCompareStub(t)
+	defer stub.Close()
+
+	push := pushEvent{Before: "before", After: "after", ProjectID: 15}
+	tests := []struct {
+		name    string
+		gitlab  gitlab
+		push    pushEvent
+		want    []string
+		wantErr bool
+	}{
+		{
+			"compare",
+			gitlab{URL: stub.URL, Token: "secret"},
+			push,
+			[]string{"new/file", "old/file", "sub/a"},
+			false,
+		},
+		{
+			"wrong_token",
+			gitlab{URL: stub.URL, Token: "wrong"},
+			push,
+			[]string{},
+			true,
+		},
+		{
+			"no_token",
+			gitlab{URL: stub.URL},
+			push,
+			[]string{},
+			true,
+		},
+		{
+			"no_url",
+			gitlab{Token: "secret"},
+			push,
+			[]string{},
+			true,
+		},
+		{
+			"new_branch",
+			gitlab{URL: stub.URL, Token: "secret"},
+			pushEvent{Before: emptyCommit, After: "after", ProjectID: 15},
+			[]string{},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{param: parameters{gitlab: tt.gitlab}}
+			got, err := s.gitlabCompareFiles(context.Background(), tt.push)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gitlabCompareFiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gitlabCompareFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
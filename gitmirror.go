@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const gitTimeout = 5 * time.Minute // timeout of a single git command
+
+var commitPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// gitMirror keeps bare mirrors of repositories in a cache directory to
+// compute the files changed between two commits
+type gitMirror struct {
+	dir string
+
+	mu    sync.Mutex
+	repos map[string]*sync.Mutex
+}
+
+func newGitMirror(dir string) (*gitMirror, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &gitMirror{dir: dir, repos: make(map[string]*sync.Mutex)}, nil
+}
+
+// lock returns the locked lock of the mirror of repo, so mirrors of
+// different repos are updated in parallel
+func (g *gitMirror) lock(repo string) *sync.Mutex {
+	g.mu.Lock()
+	l, ok := g.repos[repo]
+	if !ok {
+		l = new(sync.Mutex)
+		g.repos[repo] = l
+	}
+	g.mu.Unlock()
+
+	l.Lock()
+
+	return l
+}
+
+// path returns the directory of the mirror of repo
+func (g *gitMirror) path(repo string) string {
+	h := sha256.Sum256([]byte(repo))
+
+	return filepath.Join(g.dir, hex.EncodeToString(h[:])+".git")
+}
+
+// update clones the mirror of repo or fetches it, if commit is unknown
+func (g *gitMirror) update(ctx context.Context, repo, commit string) error {
+	l := loggerFrom(ctx)
+	path := g.path(repo)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		l.info("creating mirror", "repo", repo, "path", path)
+
+		_, err := runGit("", "clone", "--mirror", "--quiet", "--", repo, path)
+
+		return err
+	}
+
+	if _, err := runGit(path, "cat-file", "-e", commit+"^{commit}"); err == nil {
+		return nil
+	}
+
+	l.info("fetching mirror", "repo", repo)
+
+	_, err := runGit(path, "fetch", "--prune", "--quiet", "origin")
+
+	return err
+}
+
+// changedFiles returns the files changed between the commits before and
+// after of repo. If before is empty or the null commit, the files changed
+// by after itself are returned.
+func (g *gitMirror) changedFiles(ctx context.Context, repo, before, after string) ([]string, error) {
+	if err := validateRevisions(before, after); err != nil {
+		return []string{}, err
+	}
+
+	defer g.lock(repo).Unlock()
+
+	if err := g.update(ctx, repo, after); err != nil {
+		return []string{}, err
+	}
+
+	files, err := diffFiles(g.path(repo), before, after)
+	if err != nil {
+		return files, err
+	}
+
+	loggerFrom(ctx).info("mirror reported changed files", "files", len(files))
+
+	return files, nil
+}
+
+// isNullCommit reports whether commit is empty or the null commit git
+// reports for created or deleted branches
+func isNullCommit(commit string) bool {
+	return strings.Trim(commit, "0") == ""
+}
+
+// validateRevisions makes sure before and after are commit SHAs and not
+// options or revision expressions handed to git
+func validateRevisions(before, after string) error {
+	if !commitPattern.MatchString(after) {
+		return errors.New("invalid commit: " + after)
+	}
+
+	if !isNullCommit(before) && !commitPattern.MatchString(before) {
+		return errors.New("invalid commit: " + before)
+	}
+
+	return nil
+}
+
+// diffFiles returns the files changed between before and after in the
+// repository at dir. An empty dir uses the repository git finds itself.
+func diffFiles(dir, before, after string) ([]string, error) {
+	files := []string{}
+
+	var (
+		out []byte
+		err error
+	)
+	if isNullCommit(before) {
+		out, err = runGit(dir, "diff-tree", "-r", "--root", "--no-commit-id", "--name-only", after)
+	} else {
+		out, err = runGit(dir, "diff", "--name-only", before, after)
+	}
+	if err != nil {
+		return files, err
+	}
+
+	for _, file := range strings.Split(string(out), "\n") {
+		files = append(files, strings.TrimSpace(file))
+	}
+
+	files = uniqueNonEmptyElementsOf(files)
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// runGit runs git with args in dir and returns its output. Git is killed
+// after the git timeout.
+func runGit(dir string, args ...string) ([]byte, error) {
+	command := args[0]
+
+	if dir != "" {
+		args = append([]string{"--git-dir", dir}, args...)
+	}
+
+	var stderr bytes.Buffer
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return out, errors.New("git " + command + " failed: " + strings.TrimSpace(stderr.String()) + ": " + err.Error())
+	}
+
+	return out, nil
+}
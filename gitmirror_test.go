@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testGit runs git in the working tree at path and returns its output
+func testGit(t *testing.T, path string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", path}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitTestFiles writes and commits files in the working tree at path and
+// returns the SHA of the new commit
+func commitTestFiles(t *testing.T, path string, files ...string) string {
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(path, file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, file), []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testGit(t, path, "add", "-A")
+	testGit(t, path, "commit", "--quiet", "-m", "commit "+strings.Join(files, " "))
+
+	return testGit(t, path, "rev-parse", "HEAD")
+}
+
+// createTestRepo creates a git repository on branch master with two
+// commits and returns its url and the SHAs of both commits
+func createTestRepo(t *testing.T, dir string) (string, string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	path := filepath.Join(dir, "origin")
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	testGit(t, path, "init", "--quiet")
+	testGit(t, path, "checkout", "--quiet", "-b", "master")
+
+	first := commitTestFiles(t, path, "README.md", "sub1/a")
+	second := commitTestFiles(t, path, "sub2/b", "sub1/a2")
+
+	return "file://" + path, first, second
+}
+
+func Test_gitMirror_changedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trigger-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, first, second := createTestRepo(t, dir)
+
+	mirror, err := newGitMirror(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		before  string
+		after   string
+		want    []string
+		wantErr bool
+	}{
+		{"range", first, second, []string{"sub1/a2", "sub2/b"}, false},
+		{"new_branch", emptyCommit, first, []string{"README.md", "sub1/a"}, false},
+		{"invalid_after", first, "--upload-pack=evil", []string{}, true},
+		{"invalid_before", "HEAD~1", second, []string{}, true},
+		{"unknown_commit", first, "0123456789abcdef0123456789abcdef01234567", []string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mirror.changedFiles(context.Background(), repo, tt.before, tt.after)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("changedFiles() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changedFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_handlePlainGetMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trigger-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, first, second := createTestRepo(t, dir)
+
+	mirror, err := newGitMirror(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := server{
+		mapping: map[string][]string{
+			repo + "|master|sub1": {"job1"},
+			repo + "|master|sub2": {"job2"},
+			repo + "|master|doc":  {"job3"},
+		},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		mirror:         mirror,
+		param:          parameters{proxy: proxy{QuietPeriod: 5, FileMatching: true}},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/?repo="+repo+"&branch=master&before="+first+"&after="+second, nil)
+	http.HandlerFunc(s.handlePlainGet()).ServeHTTP(w, r)
+
+	if status := w.Result().StatusCode; status != http.StatusAccepted {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+	}
+	if len(s.timeKeeper) != 2 || s.timeKeeper["job1"] == nil || s.timeKeeper["job2"] == nil {
+		t.Errorf("handler scheduled wrong jobs: %v", s.timeKeeper)
+	}
+	for _, pj := range s.timeKeeper {
+		pj.timer.Stop()
+	}
+}
+
+func Test_server_resolveFilesUnmapped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trigger-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, first, second := createTestRepo(t, dir)
+
+	mirror, err := newGitMirror(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := server{
+		mapping: map[string][]string{"file:///other|master|sub1": {"job1"}},
+		mirror:  mirror,
+		param:   parameters{proxy: proxy{FileMatching: true}},
+	}
+
+	files, allFiles := s.resolveFiles(context.Background(), repo, nil, first, second)
+	if len(files) != 0 || allFiles {
+		t.Errorf("resolveFiles() = %v, %v, want no files", files, allFiles)
+	}
+
+	entries, err := ioutil.ReadDir(mirror.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unmapped repo was mirrored: %v", entries)
+	}
+}
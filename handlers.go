@@ -1,80 +1,211 @@
 package main
 
 import (
-	"log"
-	"net"
 	"net/http"
-	"strconv"
-	"time"
 )
 
 func (s *server) handlePlainGet() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Print("handling new request")
+		ctx := r.Context()
+		l := loggerFrom(ctx)
+
+		l.info("handling new request")
 
 		repo, branch, files, err := parseGetRequest(r, s.param.proxy.FileMatching)
 
 		if err != nil {
-			log.Print(err)
-			log.Print("aborting request handling")
-
-			w.WriteHeader(http.StatusBadRequest)
+			metrics.inc(metricParseFailures, "get")
+			writeBadRequest(ctx, w, err)
 
 			return
 		}
 
-		if err := s.processMatching(repo, branch, files); err != nil {
-			log.Print(err)
-			http.NotFound(w, r)
+		before, after := parseGetRevisions(r)
+		ctx = withAuditEvent(ctx, newAuditEvent(r, "get", before, after))
 
-			return
-		}
+		resp := newTriggerResponse()
+		jobs, err := s.processRevisions(ctx, repo, branch, files, before, after, nil)
+		resp.add(repo, branch, jobs, err)
 
-		w.WriteHeader(http.StatusOK)
+		writeTriggerResponse(w, resp)
 
-		log.Print("handling of request finished")
+		l.info("handling of request finished")
 	}
 }
 
 func (s *server) handleJSONPost() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Print("handling new request")
+		ctx := r.Context()
+		l := loggerFrom(ctx)
+
+		l.info("handling new request")
+
+		if r.Header.Get("X-Gitlab-Event") == "Merge Request Hook" {
+			s.handleMergeRequest(w, r)
+
+			return
+		}
+
+		if r.Header.Get("X-GitHub-Event") == "pull_request" {
+			s.handlePullRequest(w, r)
+
+			return
+		}
 
-		repos, branch, files, err := parseJSONRequest(r, s.param.proxy.FileMatching)
+		push, err := parseJSONRequest(r, s.param.proxy.FileMatching)
 
 		if err != nil {
-			log.Print(err)
-			log.Print("aborting request handling")
+			metrics.inc(metricParseFailures, "gitlab")
+			writeBadRequest(ctx, w, err)
+
+			return
+		}
 
-			w.WriteHeader(http.StatusBadRequest)
+		ctx = withAuditEvent(ctx, newAuditEvent(r, "gitlab", push.Before, push.After))
+		resp := newTriggerResponse()
+
+		if s.skipPush(ctx, push.Pusher, push.Commits, !push.Truncated) {
+			l.info("skipping push", "pusher", push.Pusher)
+			resp.Skipped = "skip marker or ignored author"
+			writeTriggerResponse(w, resp)
 
 			return
 		}
 
-		for _, repo := range repos {
-			if err := s.processMatching(repo, branch, files); err != nil {
-				log.Print(err)
+		files, allFiles := s.resolvePushFiles(ctx, push)
+		push.Files = files
+
+		for _, repo := range push.Repos {
+			var jobs []scheduledJob
+			if allFiles {
+				jobs, err = s.processBranch(ctx, repo, push.Branch, nil)
+			} else {
+				jobs, err = s.processMatching(ctx, repo, push.Branch, push.Files)
 			}
+			resp.add(repo, push.Branch, jobs, err)
 		}
 
-		w.WriteHeader(http.StatusOK)
+		writeTriggerResponse(w, resp)
 
-		log.Print("handling of request finished")
+		l.info("handling of request finished")
 	}
 }
 
-func (s *server) handleReadiness() http.HandlerFunc {
+func (s *server) handleEvent() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if len(s.mappingHash) == 0 {
-			w.WriteHeader(http.StatusInternalServerError)
+		ctx := r.Context()
+		l := loggerFrom(ctx)
+
+		l.info("handling new event")
+
+		ev, err := parseEventRequest(r)
+
+		if err != nil {
+			metrics.inc(metricParseFailures, "event")
+			writeBadRequest(ctx, w, err)
+
+			return
 		}
 
-		conn, _ := net.DialTimeout("tcp", net.JoinHostPort("", strconv.Itoa(s.param.proxy.port)), time.Millisecond*time.Duration(100))
-		if conn == nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		ctx = withAuditEvent(ctx, newAuditEvent(r, "event", ev.Before, ev.After))
+		resp := newTriggerResponse()
+		jobs, err := s.processRevisions(ctx, ev.Repo, ev.Branch, ev.Files, ev.Before, ev.After, nil)
+		resp.add(ev.Repo, ev.Branch, jobs, err)
+
+		writeTriggerResponse(w, resp)
+
+		l.info("handling of event finished")
+	}
+}
+
+func (s *server) handleMergeRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := loggerFrom(ctx)
+
+	mr, err := parseMergeRequest(r)
+
+	if err != nil {
+		metrics.inc(metricParseFailures, "gitlab")
+		writeBadRequest(ctx, w, err)
+
+		return
+	}
+
+	ctx = withAuditEvent(ctx, newAuditEvent(r, "gitlab_merge_request", "", mr.SHA))
+	resp := newTriggerResponse()
+
+	if skipped := mr.skipReason(s.param.proxy.MRActions); skipped != "" {
+		l.info("ignoring merge request", "iid", mr.IID, "action", mr.Action, "reason", skipped)
+
+		resp.Skipped = skipped
+		writeTriggerResponse(w, resp)
+
+		return
+	}
+
+	for _, repo := range mr.Repos {
+		jobs, err := s.processChangeRequest(ctx, mergeRequestSection, repo, mr.TargetBranch, mr.SourceBranch, mr.jobParameters())
+		resp.add(repo, mr.TargetBranch, jobs, err)
+	}
+
+	writeTriggerResponse(w, resp)
+
+	l.info("handling of request finished")
+}
+
+func (s *server) handlePullRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	l := loggerFrom(ctx)
+
+	pr, err := parsePullRequest(r)
+
+	if err != nil {
+		metrics.inc(metricParseFailures, "github")
+		writeBadRequest(ctx, w, err)
+
+		return
+	}
+
+	ctx = withAuditEvent(ctx, newAuditEvent(r, "github_pull_request", "", pr.SHA))
+	resp := newTriggerResponse()
+
+	if pr.Action == "converted_to_draft" && s.param.proxy.PRSkipDraft {
+		cancelled := []string{}
+		for _, repo := range pr.Repos {
+			cancelled = append(cancelled, s.cancelChange(repo, pr.jobParameters())...)
 		}
-		conn.Close()
+		l.info("pull request converted to draft", "number", pr.Number, "cancelled", cancelled)
+
+		resp.Skipped = "pull request converted to draft"
+		writeTriggerResponse(w, resp)
+
+		return
+	}
+
+	if !acceptAction(s.param.proxy.PRActions, pr.Action) {
+		l.info("ignoring pull request", "number", pr.Number, "action", pr.Action)
 
-		w.WriteHeader(http.StatusOK)
+		resp.Skipped = "ignored pull request action: " + pr.Action
+		writeTriggerResponse(w, resp)
+
+		return
 	}
+
+	if pr.Draft && s.param.proxy.PRSkipDraft {
+		l.info("ignoring draft pull request", "number", pr.Number)
+
+		resp.Skipped = "draft pull request"
+		writeTriggerResponse(w, resp)
+
+		return
+	}
+
+	for _, repo := range pr.Repos {
+		jobs, err := s.processChangeRequest(ctx, pullRequestSection, repo, pr.BaseBranch, pr.HeadBranch, pr.jobParameters())
+		resp.add(repo, pr.BaseBranch, jobs, err)
+	}
+
+	writeTriggerResponse(w, resp)
+
+	l.info("handling of request finished")
 }
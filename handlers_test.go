@@ -3,11 +3,23 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
-	"time"
 )
 
+// triggerStatus returns the status code of a trigger request creating hits timers
+func triggerStatus(hits int) int {
+	if hits > 0 {
+		return http.StatusAccepted
+	}
+
+	return http.StatusOK
+}
+
 func Test_server_handlePlainGet(t *testing.T) {
 	type args struct {
 		w *httptest.ResponseRecorder
@@ -22,8 +34,9 @@ func Test_server_handlePlainGet(t *testing.T) {
 		{
 			"simple_match",
 			server{
-				mapping:    map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -33,13 +46,14 @@ func Test_server_handlePlainGet(t *testing.T) {
 				},
 			},
 			args{w: httptest.NewRecorder(), r: httptest.NewRequest("GET", "/?repo=git://repo/magic/repo&branch=branch&files=file", nil)},
-			http.StatusOK,
+			http.StatusAccepted,
 		},
 		{
 			"simple_nomatch",
 			server{
-				mapping:    map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -49,13 +63,31 @@ func Test_server_handlePlainGet(t *testing.T) {
 				},
 			},
 			args{w: httptest.NewRecorder(), r: httptest.NewRequest("GET", "/?repo=git://repo/magic/repa&branch=branch&files=file", nil)},
-			http.StatusNotFound,
+			http.StatusOK,
+		},
+		{
+			"no_files_without_mirror",
+			server{
+				mapping:        map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param: parameters{
+					proxy: proxy{
+						QuietPeriod:  5,
+						FileMatching: true,
+						SemanticRepo: "git://repo/magic/",
+					},
+				},
+			},
+			args{w: httptest.NewRecorder(), r: httptest.NewRequest("GET", "/?repo=git://repo/magic/repo&branch=branch", nil)},
+			http.StatusOK,
 		},
 		{
 			"bad_request",
 			server{
-				mapping:    map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/magic/repo|branch|repo/file": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -74,7 +106,7 @@ func Test_server_handlePlainGet(t *testing.T) {
 			handler.ServeHTTP(tt.args.w, tt.args.r)
 			if status := tt.args.w.Result().StatusCode; status != tt.want {
 				t.Errorf("handler returned wrong status code: got %v want %v",
-					status, http.StatusOK)
+					status, tt.want)
 			}
 		})
 	}
@@ -150,8 +182,9 @@ func Test_server_handleJSONPost(t *testing.T) {
 		{
 			"semantic_match",
 			server{
-				mapping:    map[string][]string{"git@repo:magic/repo.git|branch|repo/file": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git@repo:magic/repo.git|branch|repo/file": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -167,8 +200,9 @@ func Test_server_handleJSONPost(t *testing.T) {
 		{
 			"bad_request",
 			server{
-				mapping:    map[string][]string{"git@repo:magic/repo.git|branch|repo/file": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git@repo:magic/repo.git|branch|repo/file": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -178,7 +212,7 @@ func Test_server_handleJSONPost(t *testing.T) {
 				},
 			},
 			args{w: httptest.NewRecorder(), r: httptest.NewRequest("POST", "/json", body)},
-			http.StatusOK,
+			http.StatusAccepted,
 			1,
 		},
 	}
@@ -188,7 +222,7 @@ func Test_server_handleJSONPost(t *testing.T) {
 			handler.ServeHTTP(tt.args.w, tt.args.r)
 			if status := tt.args.w.Result().StatusCode; status != tt.wantHTTP {
 				t.Errorf("handler returned wrong status code: got %v want %v",
-					status, http.StatusOK)
+					status, tt.wantHTTP)
 			}
 			hits := len(tt.s.timeKeeper)
 			if tt.wantHits != hits {
@@ -197,3 +231,431 @@ func Test_server_handleJSONPost(t *testing.T) {
 		})
 	}
 }
+
+func Test_server_handleMergeRequest(t *testing.T) {
+	body := func(action, oldrev string) *strings.Reader {
+		return strings.NewReader(`{
+			"object_kind": "merge_request",
+			"object_attributes": {
+			  "iid": 7,
+			  "action": "` + action + `",
+			  "oldrev": "` + oldrev + `",
+			  "source_branch": "feature",
+			  "target_branch": "master",
+			  "target": {
+				"git_ssh_url": "git@repo:magic/repo.git",
+				"git_http_url": "http://repo/magic/repo.git"
+			  }
+			}
+		  }`)
+	}
+	tests := []struct {
+		name     string
+		s        server
+		body     *strings.Reader
+		wantHTTP int
+		wantHits int
+	}{
+		{
+			"open",
+			server{
+				mapping:        map[string][]string{"merge_request|git@repo:magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5, MRActions: []string{"open"}}},
+			},
+			body("open", ""),
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"ignored_action",
+			server{
+				mapping:        map[string][]string{"merge_request|git@repo:magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5, MRActions: []string{"open"}}},
+			},
+			body("merge", ""),
+			http.StatusOK,
+			0,
+		},
+		{
+			"update_with_commits",
+			server{
+				mapping:        map[string][]string{"merge_request|git@repo:magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5, MRActions: []string{"open", "update"}}},
+			},
+			body("update", "0123456789abcdef0123456789abcdef01234567"),
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"update_without_commits",
+			server{
+				mapping:        map[string][]string{"merge_request|git@repo:magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5, MRActions: []string{"open", "update"}}},
+			},
+			body("update", ""),
+			http.StatusOK,
+			0,
+		},
+		{
+			"push_mapping_only",
+			server{
+				mapping:        map[string][]string{"git@repo:magic/repo.git|master": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5}},
+			},
+			body("open", ""),
+			http.StatusOK,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/json", tt.body)
+			r.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+			handler := http.HandlerFunc(tt.s.handleJSONPost())
+			handler.ServeHTTP(w, r)
+			if status := w.Result().StatusCode; status != tt.wantHTTP {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantHTTP)
+			}
+			if hits := len(tt.s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			for _, pj := range tt.s.timeKeeper {
+				if got := pj.params.Get("MR_IID"); got != "7" {
+					t.Errorf("timer has wrong MR_IID parameter: got %v want 7", got)
+				}
+				pj.timer.Stop()
+			}
+		})
+	}
+}
+
+func Test_server_handlePullRequest(t *testing.T) {
+	body := func(action string, draft bool) *strings.Reader {
+		d := "false"
+		if draft {
+			d = "true"
+		}
+		return strings.NewReader(`{
+			"action": "` + action + `",
+			"number": 3,
+			"pull_request": {
+			  "draft": ` + d + `,
+			  "head": {"ref": "feature"},
+			  "base": {
+				"ref": "master",
+				"repo": {"clone_url": "https://repo/magic/repo.git"}
+			  }
+			}
+		  }`)
+	}
+	prParam := parameters{proxy: proxy{QuietPeriod: 5, PRActions: []string{"opened", "synchronize", "ready_for_review"}, PRSkipDraft: true}}
+	tests := []struct {
+		name     string
+		s        server
+		body     *strings.Reader
+		wantHits int
+	}{
+		{
+			"base_match",
+			server{
+				mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          prParam,
+			},
+			body("opened", false),
+			1,
+		},
+		{
+			"head_match",
+			server{
+				mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|*|feature": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          prParam,
+			},
+			body("synchronize", false),
+			1,
+		},
+		{
+			"head_mismatch",
+			server{
+				mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|master|other": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          prParam,
+			},
+			body("opened", false),
+			0,
+		},
+		{
+			"closed",
+			server{
+				mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          prParam,
+			},
+			body("closed", false),
+			0,
+		},
+		{
+			"ready_for_review",
+			server{
+				mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          prParam,
+			},
+			body("ready_for_review", false),
+			1,
+		},
+		{
+			"draft",
+			server{
+				mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|master|*": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          prParam,
+			},
+			body("opened", true),
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/json", tt.body)
+			r.Header.Set("X-GitHub-Event", "pull_request")
+			handler := http.HandlerFunc(tt.s.handleJSONPost())
+			handler.ServeHTTP(w, r)
+			if status := w.Result().StatusCode; status != triggerStatus(tt.wantHits) {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, triggerStatus(tt.wantHits))
+			}
+			if hits := len(tt.s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			for _, pj := range tt.s.timeKeeper {
+				if got := pj.params.Get("PR_NUMBER"); got != "3" {
+					t.Errorf("timer has wrong PR_NUMBER parameter: got %v want 3", got)
+				}
+				pj.timer.Stop()
+			}
+		})
+	}
+}
+
+func Test_server_handlePullRequestConvertedToDraft(t *testing.T) {
+	body := func(action string, number int, draft bool) *strings.Reader {
+		return strings.NewReader(`{
+			"action": "` + action + `",
+			"number": ` + strconv.Itoa(number) + `,
+			"pull_request": {
+			  "draft": ` + strconv.FormatBool(draft) + `,
+			  "head": {"ref": "feature"},
+			  "base": {
+				"ref": "master",
+				"repo": {"clone_url": "https://repo/magic/repo.git"}
+			  }
+			}
+		  }`)
+	}
+	s := server{
+		mapping:        map[string][]string{"pull_request|https://repo/magic/repo.git|master|*": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{QuietPeriod: 5, PRActions: []string{"opened"}, PRSkipDraft: true}},
+	}
+	defer s.cancelJobs()
+
+	post := func(b *strings.Reader) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/json", b)
+		r.Header.Set("X-GitHub-Event", "pull_request")
+		s.handleJSONPost()(w, r)
+
+		return w.Code
+	}
+
+	post(body("opened", 3, false))
+	post(body("opened", 33, false))
+	if status := post(body("converted_to_draft", 3, true)); status != http.StatusOK {
+		t.Errorf("converted to draft answered with %v", status)
+	}
+
+	if _, ok := s.timeKeeper["job|repo/magic/repo|PR_NUMBER=33"]; !ok || len(s.timeKeeper) != 1 {
+		t.Errorf("pending jobs after conversion to draft = %v, want only the other pull request", s.timeKeeper)
+	}
+}
+
+func Test_server_handleJSONPostTruncated(t *testing.T) {
+	stub := newGitlabCompareStub(t)
+	defer stub.Close()
+
+	body := `{
+		"object_kind": "push",
+		"before": "before",
+		"after": "after",
+		"ref": "refs/heads/master",
+		"project": {
+		  "id": 15,
+		  "git_http_url": "http://repo/magic/repo.git"
+		},
+		"commits": [
+		  {"added": ["sub/b"], "modified": [], "removed": []}
+		],
+		"total_commits_count": 21
+	  }`
+	mapping := map[string][]string{
+		"http://repo/magic/repo.git|master|new": {"newjob"},
+		"http://repo/magic/repo.git|master|doc": {"docjob"},
+	}
+	tests := []struct {
+		name     string
+		gitlab   gitlab
+		wantJobs []string
+	}{
+		{
+			"compare",
+			gitlab{URL: stub.URL, Token: "secret"},
+			[]string{"newjob"},
+		},
+		{
+			"fallback",
+			gitlab{URL: stub.URL, Token: "wrong"},
+			[]string{"docjob", "newjob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				mapping:        mapping,
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param: parameters{
+					gitlab: tt.gitlab,
+					proxy:  proxy{QuietPeriod: 5, FileMatching: true},
+				},
+			}
+			w := httptest.NewRecorder()
+			handler := http.HandlerFunc(s.handleJSONPost())
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "/json", strings.NewReader(body)))
+			if status := w.Result().StatusCode; status != http.StatusAccepted {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusAccepted)
+			}
+			var jobs []string
+			for job, pj := range s.timeKeeper {
+				jobs = append(jobs, job)
+				pj.timer.Stop()
+			}
+			sort.Strings(jobs)
+			if !reflect.DeepEqual(jobs, tt.wantJobs) {
+				t.Errorf("handler scheduled jobs %v, want %v", jobs, tt.wantJobs)
+			}
+		})
+	}
+}
+
+func Test_server_handleEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantHTTP int
+		wantHits int
+	}{
+		{
+			"match",
+			`{"repo": "git://repo/magic/repo", "branch": "master", "files": ["sub/file"]}`,
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"nomatch",
+			`{"repo": "git://repo/magic/repo", "branch": "master", "files": ["other/file"]}`,
+			http.StatusOK,
+			0,
+		},
+		{
+			"bad_request",
+			`{"branch": "master"}`,
+			http.StatusBadRequest,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				mapping:        map[string][]string{"git://repo/magic/repo|master|sub": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5, FileMatching: true}},
+			}
+			w := httptest.NewRecorder()
+			http.HandlerFunc(s.handleEvent()).ServeHTTP(w, httptest.NewRequest("POST", "/event", strings.NewReader(tt.body)))
+			if status := w.Result().StatusCode; status != tt.wantHTTP {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantHTTP)
+			}
+			if hits := len(s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			for _, pj := range s.timeKeeper {
+				pj.timer.Stop()
+			}
+		})
+	}
+}
+
+func Test_server_handleJSONPostSkip(t *testing.T) {
+	body := func(message string) *strings.Reader {
+		return strings.NewReader(`{
+			"object_kind": "push",
+			"ref": "refs/heads/master",
+			"user_username": "jsmith",
+			"project": {"git_http_url": "http://repo/magic/repo.git"},
+			"commits": [
+			  {"message": "` + message + `", "author": {"name": "John", "email": "john@example.com"}, "added": ["file"]}
+			],
+			"total_commits_count": 1
+		  }`)
+	}
+	tests := []struct {
+		name     string
+		body     *strings.Reader
+		wantHits int
+	}{
+		{"regular", body("fix bug"), 1},
+		{"skip_marker", body("release 1.0 [skip ci]"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := server{
+				mapping:        map[string][]string{"http://repo/magic/repo.git|master": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 5, SkipMarkers: []string{"[skip ci]"}}},
+			}
+			w := httptest.NewRecorder()
+			http.HandlerFunc(s.handleJSONPost()).ServeHTTP(w, httptest.NewRequest("POST", "/json", tt.body))
+			if status := w.Result().StatusCode; status != triggerStatus(tt.wantHits) {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, triggerStatus(tt.wantHits))
+			}
+			if hits := len(s.timeKeeper); hits != tt.wantHits {
+				t.Errorf("handler created wrong number of timers: got %v want %v", hits, tt.wantHits)
+			}
+			for _, pj := range s.timeKeeper {
+				pj.timer.Stop()
+			}
+		})
+	}
+}
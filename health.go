@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn" // failed, but not required for readiness
+	checkFail = "fail"
+
+	staleFactor  = 3               // refresh intervals after which a mapping is stale
+	probeTimeout = 2 * time.Second // timeout of the jenkins probe
+)
+
+// health records the state the readiness checks are based on
+type health struct {
+	mu sync.Mutex
+	// refreshed is the time of the last successful mapping refresh
+	refreshed  time.Time
+	refreshErr error
+
+	probeMu  sync.Mutex
+	probed   time.Time
+	probeErr error
+}
+
+// healthCheck is the result of a single readiness check
+type healthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthReport is the detail view of a health endpoint
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+func newHealth() *health {
+	return &health{}
+}
+
+// recordRefresh records the outcome of a mapping refresh
+func (h *health) recordRefresh(err error) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.refreshErr = err
+	if err == nil {
+		h.refreshed = time.Now()
+	}
+}
+
+// lastRefresh returns the time of the last successful mapping refresh and
+// the error of the last refresh
+func (h *health) lastRefresh() (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.refreshed, h.refreshErr
+}
+
+// probeJenkins returns the outcome of the last jenkins probe, probing again
+// if it is older than the probe interval
+func (s *server) probeJenkins() (time.Time, error) {
+	h := s.health
+
+	h.probeMu.Lock()
+	defer h.probeMu.Unlock()
+
+	if !h.probed.IsZero() && time.Since(h.probed) < s.param.jenkins.ProbeInterval {
+		return h.probed, h.probeErr
+	}
+
+	h.probed = time.Now()
+	h.probeErr = s.jenkinsReachable()
+
+	return h.probed, h.probeErr
+}
+
+// jenkinsReachable requests the jenkins api. Every answer but a server error
+// counts, jenkins may deny the api to the trigger user.
+func (s *server) jenkinsReachable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(s.param.jenkins.URL, "/")+"/api/json", nil)
+	if err != nil {
+		return err
+	}
+
+	if s.param.jenkins.User != "" {
+		req.SetBasicAuth(s.param.jenkins.User, s.param.jenkins.Token)
+	}
+
+	resp, err := s.jenkinsClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("jenkins answered with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// readinessChecks runs all readiness checks
+func (s *server) readinessChecks() []healthCheck {
+	checks := []healthCheck{s.checkMappingLoaded()}
+
+	if s.health == nil {
+		return checks
+	}
+
+	return append(checks, s.checkMappingFresh(), s.checkJenkins())
+}
+
+func (s *server) checkMappingLoaded() healthCheck {
+	c := healthCheck{Name: "mapping", Status: checkOK}
+
+	if s.mappingHash == "" {
+		c.Status = checkFail
+		c.Message = "no mapping loaded"
+	}
+
+	return c
+}
+
+// checkMappingFresh fails if the mapping source couldn't be checked for
+// several refresh intervals
+func (s *server) checkMappingFresh() healthCheck {
+	c := healthCheck{Name: "mapping_refresh", Status: checkOK}
+
+	refreshed, err := s.health.lastRefresh()
+	if refreshed.IsZero() {
+		c.Status = checkFail
+		c.Message = "mapping never refreshed"
+
+		return c
+	}
+
+	age := time.Since(refreshed).Round(time.Second)
+	c.Message = "refreshed " + age.String() + " ago"
+
+	if age > staleFactor*s.mappingRefreshInterval {
+		c.Status = checkFail
+		c.Message = "mapping is stale, " + c.Message
+	}
+
+	if err != nil {
+		c.Message += ", last refresh failed: " + err.Error()
+	}
+
+	return c
+}
+
+// checkJenkins reports whether jenkins was reachable at the last probe. It
+// only fails readiness if jenkins is required.
+func (s *server) checkJenkins() healthCheck {
+	c := healthCheck{Name: "jenkins", Status: checkOK}
+
+	probed, err := s.probeJenkins()
+	c.Message = "probed " + time.Since(probed).Round(time.Second).String() + " ago"
+
+	if err != nil {
+		c.Status = checkWarn
+		if s.param.jenkins.RequireReady {
+			c.Status = checkFail
+		}
+		c.Message += ": " + err.Error()
+	}
+
+	return c
+}
+
+// newHealthReport summarizes checks, it fails if any check failed
+func newHealthReport(checks []healthCheck) healthReport {
+	report := healthReport{Status: checkOK, Checks: checks}
+	for _, c := range checks {
+		if c.Status == checkFail {
+			report.Status = checkFail
+		}
+	}
+
+	return report
+}
+
+// writeHealthReport answers with 200 or 503 and the status of the report.
+// With the query parameter "verbose" the report lists every check as json.
+func writeHealthReport(w http.ResponseWriter, r *http.Request, report healthReport) {
+	status := http.StatusOK
+	if report.Status != checkOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	if _, ok := r.URL.Query()["verbose"]; ok {
+		writeJSON(w, status, report)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, report.Status)
+}
+
+// handleLiveness reports that the process serves requests
+func (s *server) handleLiveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeHealthReport(w, r, newHealthReport([]healthCheck{{Name: "process", Status: checkOK}}))
+	}
+}
+
+// handleReadiness reports whether the proxy is able to handle events: a
+// mapping is loaded and was refreshed recently. Jenkins is probed as well.
+func (s *server) handleReadiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := newHealthReport(s.readinessChecks())
+		if report.Status != checkOK {
+			loggerFrom(r.Context()).warn("not ready", "checks", failedChecks(report.Checks))
+		}
+
+		writeHealthReport(w, r, report)
+	}
+}
+
+// failedChecks lists the names of the failed checks
+func failedChecks(checks []healthCheck) string {
+	var failed []string
+	for _, c := range checks {
+		if c.Status == checkFail {
+			failed = append(failed, c.Name)
+		}
+	}
+
+	return strings.Join(failed, ",")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_server_handleReadiness(t *testing.T) {
+	var probes int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer up.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	tests := []struct {
+		name        string
+		hash        string
+		refreshed   time.Duration // age of the last refresh, never if 0
+		jenkinsURL  string
+		required    bool
+		wantStatus  int
+		wantJenkins string
+	}{
+		{"ready", "hash", time.Minute, up.URL, false, http.StatusOK, checkOK},
+		{"no_mapping", "", time.Minute, up.URL, false, http.StatusServiceUnavailable, checkOK},
+		{"never_refreshed", "hash", 0, up.URL, false, http.StatusServiceUnavailable, checkOK},
+		{"stale", "hash", time.Hour, up.URL, false, http.StatusServiceUnavailable, checkOK},
+		{"jenkins_down", "hash", time.Minute, down.URL, false, http.StatusOK, checkWarn},
+		{"jenkins_down_required", "hash", time.Minute, down.URL, true, http.StatusServiceUnavailable, checkFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{
+				mappingHash:            tt.hash,
+				mappingRefreshInterval: 5 * time.Minute,
+				health:                 newHealth(),
+				param: parameters{
+					jenkins: jenkins{URL: tt.jenkinsURL, ProbeInterval: time.Minute, RequireReady: tt.required},
+				},
+			}
+			if tt.refreshed > 0 {
+				s.health.refreshed = time.Now().Add(-tt.refreshed)
+			}
+
+			w := httptest.NewRecorder()
+			s.handleReadiness()(w, httptest.NewRequest("GET", "/readyz?verbose", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			var report healthReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Checks) != 3 || report.Checks[2].Name != "jenkins" || report.Checks[2].Status != tt.wantJenkins {
+				t.Errorf("checks = %+v", report.Checks)
+			}
+		})
+	}
+
+	if n := atomic.LoadInt32(&probes); n != 4 {
+		t.Errorf("jenkins probed %d times, want 4", n)
+	}
+}
+
+func Test_server_probeJenkins(t *testing.T) {
+	var probes int32
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}))
+	defer stub.Close()
+
+	s := &server{
+		health: newHealth(),
+		param:  parameters{jenkins: jenkins{URL: stub.URL, ProbeInterval: time.Minute}},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.probeJenkins(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&probes); n != 1 {
+		t.Errorf("jenkins probed %d times, want 1", n)
+	}
+}
+
+func Test_server_handleLiveness(t *testing.T) {
+	s := &server{}
+
+	w := httptest.NewRecorder()
+	s.handleLiveness()(w, httptest.NewRequest("GET", "/livez", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("livez = %d %q", w.Code, w.Body.String())
+	}
+}
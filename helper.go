@@ -14,6 +14,15 @@ func createJobURL(jenkinsURL, job string) string {
 	return string(jenkinsURL + "/job/" + job + "/build")
 }
 
+// createJobPageURL returns the url of the jenkins page of job
+func createJobPageURL(jenkinsURL, job string) string {
+	return jenkinsURL + "/job/" + job + "/"
+}
+
+func createParameterizedJobURL(jenkinsURL, job string) string {
+	return string(jenkinsURL + "/job/" + job + "/buildWithParameters")
+}
+
 func removeLastRune(s string) string {
 	if len(s) <= 1 {
 		return ""
@@ -79,3 +88,16 @@ func uniqueNonEmptyElementsOf(s []string) []string {
 	return us
 
 }
+
+// splitList splits a comma separated list and drops empty elements
+func splitList(s string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem != "" {
+			list = append(list, elem)
+		}
+	}
+
+	return list
+}
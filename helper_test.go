@@ -49,6 +49,13 @@ func Test_createJobURL(t *testing.T) {
 	}
 }
 
+func Test_createParameterizedJobURL(t *testing.T) {
+	got := createParameterizedJobURL("http://jenkins:8080", "test")
+	if want := "http://jenkins:8080/job/test/buildWithParameters"; got != want {
+		t.Errorf("createParameterizedJobURL() = %v, want %v", got, want)
+	}
+}
+
 func Test_removeLastRune(t *testing.T) {
 	type args struct {
 		s string
@@ -150,3 +157,22 @@ func Test_evalMappingKeys(t *testing.T) {
 		})
 	}
 }
+
+func Test_splitList(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"single", "open", []string{"open"}},
+		{"spaces_and_empty", " open, ,update,", []string{"open", "update"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitList(tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
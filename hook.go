@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const hookCommand = "hook"
+
+// runHook reads the "oldrev newrev refname" lines a git post-receive hook
+// gets on stdin and sends an event with the changed files of every updated
+// branch to the proxy
+func runHook(args []string, stdin io.Reader) error {
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+
+	proxyURL := flags.String("url", "", "url of the trigger-proxy")
+	repo := flags.String("repo", "", "url of the repository as used in the mapping")
+	failClosed := flags.Bool("fail-closed", false, "exit with an error if an event can't be delivered")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout for sending an event")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *proxyURL == "" {
+		return errors.New("no proxy url defined")
+	}
+
+	if *repo == "" {
+		return errors.New("no repo defined")
+	}
+
+	failed := 0
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		before, after, ref := fields[0], fields[1], fields[2]
+
+		if !strings.HasPrefix(ref, "refs/heads/") || isNullCommit(after) {
+			continue
+		}
+
+		ev := hookEvent{
+			Repo:   *repo,
+			Branch: strings.TrimPrefix(ref, "refs/heads/"),
+			Before: before,
+			After:  after,
+		}
+
+		matched, err := sendHookEvent(*proxyURL, ev, *timeout)
+		if err != nil {
+			log.Printf("trigger-proxy: %s: %s", ev.Branch, err)
+			failed++
+		} else if !matched {
+			log.Printf("trigger-proxy: %s: nothing matched", ev.Branch)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if failed > 0 && *failClosed {
+		return fmt.Errorf("failed to send %d events", failed)
+	}
+
+	return nil
+}
+
+// sendHookEvent computes the changed files of ev with the local repository
+// and posts it to the event endpoint of the proxy. It reports whether the
+// proxy matched any job.
+func sendHookEvent(proxyURL string, ev hookEvent, timeout time.Duration) (bool, error) {
+	if err := validateRevisions(ev.Before, ev.After); err != nil {
+		return false, err
+	}
+
+	files, err := diffFiles("", ev.Before, ev.After)
+	if err != nil {
+		return false, err
+	}
+	ev.Files = files
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return false, err
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(strings.TrimSuffix(proxyURL, "/")+"/event", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if !(200 <= resp.StatusCode && resp.StatusCode <= 299) {
+		return false, fmt.Errorf("proxy responded with status code %d", resp.StatusCode)
+	}
+
+	var result triggerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("invalid response of proxy: %s", err)
+	}
+
+	for _, r := range result.Repos {
+		if r.Error != "" {
+			return false, errors.New(r.Error)
+		}
+	}
+
+	return result.status() == http.StatusAccepted, nil
+}
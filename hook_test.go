@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_runHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trigger-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, first, second := createTestRepo(t, dir)
+
+	os.Setenv("GIT_DIR", filepath.Join(dir, "origin", ".git"))
+	defer os.Unsetenv("GIT_DIR")
+
+	var events []hookEvent
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/event" {
+			t.Errorf("event sent to %s", r.URL.Path)
+		}
+		var ev hookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events = append(events, ev)
+
+		// only master is mapped
+		resp := newTriggerResponse()
+		var jobs []scheduledJob
+		if ev.Branch == "master" {
+			jobs = []scheduledJob{{Job: "job"}}
+		}
+		resp.add(ev.Repo, ev.Branch, jobs, nil)
+		writeTriggerResponse(w, resp)
+	}))
+	defer proxy.Close()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	stdin := first + " " + second + " refs/heads/master\n" +
+		emptyCommit + " " + first + " refs/heads/feature\n" +
+		first + " " + emptyCommit + " refs/heads/deleted\n" +
+		emptyCommit + " " + first + " refs/tags/v1\n"
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantEvents []hookEvent
+		wantLog    []string
+		wantErr    bool
+	}{
+		{
+			"send",
+			[]string{"hook", "-url=" + proxy.URL, "-repo=git://repo/repo"},
+			[]hookEvent{
+				{Repo: "git://repo/repo", Branch: "master", Before: first, After: second, Files: []string{"sub1/a2", "sub2/b"}},
+				{Repo: "git://repo/repo", Branch: "feature", Before: emptyCommit, After: first, Files: []string{"README.md", "sub1/a"}},
+			},
+			[]string{"trigger-proxy: feature: nothing matched"},
+			false,
+		},
+		{
+			"fail_open",
+			[]string{"hook", "-url=" + down.URL, "-repo=git://repo/repo"},
+			nil,
+			[]string{"trigger-proxy: master: Post", "trigger-proxy: feature: Post"},
+			false,
+		},
+		{
+			"fail_closed",
+			[]string{"hook", "-url=" + down.URL, "-repo=git://repo/repo", "-fail-closed"},
+			nil,
+			nil,
+			true,
+		},
+		{
+			"no_repo",
+			[]string{"hook", "-url=" + proxy.URL},
+			nil,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+			err := runHook(tt.args, strings.NewReader(stdin))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runHook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("runHook() sent %v, want %v", events, tt.wantEvents)
+			}
+			for _, want := range tt.wantLog {
+				if !strings.Contains(logged.String(), want) {
+					t.Errorf("runHook() logged %q, want %q", logged.String(), want)
+				}
+			}
+			if strings.Contains(logged.String(), "master: nothing matched") {
+				t.Errorf("runHook() logged the matched master as %q", logged.String())
+			}
+		})
+	}
+}
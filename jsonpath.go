@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	stepKey      = iota // selects a key of an object
+	stepIndex           // selects an element of an array
+	stepWildcard        // selects every element of an array or object
+)
+
+// jsonPathStep is one step of a parsed json path expression
+type jsonPathStep struct {
+	kind  int
+	key   string
+	index int
+}
+
+// parseJSONPath parses a subset of json path expressions: "$" followed by
+// any number of ".key", "['key']", "[n]", "[*]" and ".*" steps
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	var steps []jsonPathStep
+
+	if !strings.HasPrefix(path, "$") {
+		return steps, errors.New("json path has to start with $: " + path)
+	}
+	rest := path[1:]
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			steps = append(steps, jsonPathStep{kind: stepWildcard})
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return steps, errors.New("empty key in json path: " + path)
+			}
+			steps = append(steps, jsonPathStep{kind: stepKey, key: key})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return steps, errors.New("unterminated bracket in json path: " + path)
+			}
+			inner := rest[1:end]
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{kind: stepWildcard})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{kind: stepKey, key: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return steps, errors.New("invalid index in json path: " + path)
+				}
+				steps = append(steps, jsonPathStep{kind: stepIndex, index: i})
+			}
+			rest = rest[end+1:]
+		default:
+			return steps, errors.New("invalid json path: " + path)
+		}
+	}
+
+	return steps, nil
+}
+
+// evalJSONPath returns all values selected by path in the decoded json document
+func evalJSONPath(doc interface{}, path string) ([]interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []interface{}{doc}
+	for _, step := range steps {
+		var next []interface{}
+		for _, value := range values {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				if step.kind == stepWildcard {
+					for _, elem := range v {
+						next = append(next, elem)
+					}
+				} else if elem, ok := v[step.key]; ok && step.kind == stepKey {
+					next = append(next, elem)
+				}
+			case []interface{}:
+				if step.kind == stepWildcard {
+					next = append(next, v...)
+				} else if step.kind == stepIndex && step.index < len(v) {
+					next = append(next, v[step.index])
+				}
+			}
+		}
+		values = next
+	}
+
+	return values, nil
+}
+
+// jsonPathStrings returns the strings selected by path. Selected arrays are
+// flattened, other values are formatted as text.
+func jsonPathStrings(doc interface{}, path string) ([]string, error) {
+	values, err := evalJSONPath(doc, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var strs []string
+	for len(values) > 0 {
+		value := values[0]
+		values = values[1:]
+
+		switch v := value.(type) {
+		case nil:
+		case string:
+			strs = append(strs, v)
+		case []interface{}:
+			values = append(append([]interface{}{}, v...), values...)
+		default:
+			strs = append(strs, fmt.Sprint(v))
+		}
+	}
+
+	return strs, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_jsonPathStrings(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{
+		"ref": "refs/heads/master",
+		"id": 42,
+		"repo": {"urls": ["http://a", "git://a"], "name.with.dots": "x"},
+		"commits": [
+		  {"added": ["a", "b"], "modified": ["c"]},
+		  {"added": ["d"], "modified": null}
+		]
+	  }`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantErr bool
+	}{
+		{"key", "$.ref", []string{"refs/heads/master"}, false},
+		{"number", "$.id", []string{"42"}, false},
+		{"array", "$.repo.urls", []string{"http://a", "git://a"}, false},
+		{"index", "$.repo.urls[1]", []string{"git://a"}, false},
+		{"index_out_of_range", "$.repo.urls[5]", nil, false},
+		{"quoted_key", "$.repo['name.with.dots']", []string{"x"}, false},
+		{"wildcard", "$.commits[*].added", []string{"a", "b", "d"}, false},
+		{"wildcard_null", "$.commits[*].modified", []string{"c"}, false},
+		{"missing", "$.nothing.here", nil, false},
+		{"no_root", "ref", nil, true},
+		{"bad_index", "$.repo.urls[x]", nil, true},
+		{"unterminated", "$.repo.urls[1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonPathStrings(doc, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("jsonPathStrings() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("jsonPathStrings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
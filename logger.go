@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+// parseLogLevel returns the level with the given name
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range levelNames {
+		if n == strings.ToLower(name) {
+			return logLevel(i), nil
+		}
+	}
+
+	return levelInfo, errors.New("unknown log level: " + name)
+}
+
+// logOutput is the destination shared by a logger and its children
+type logOutput struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level logLevel
+	json  bool
+}
+
+// logger writes leveled entries with key value pairs as text or json lines
+type logger struct {
+	out    *logOutput
+	fields []interface{}
+}
+
+// defaultLogger is the logger of everything not bound to a request
+var defaultLogger = &logger{out: &logOutput{w: os.Stderr, level: levelInfo}}
+
+// configureLogger sets format and level of the default logger and routes
+// the standard logger through it
+func configureLogger(format, level string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "text", "json":
+	default:
+		return errors.New("unknown log format: " + format)
+	}
+
+	defaultLogger.out.mu.Lock()
+	defaultLogger.out.level = lvl
+	defaultLogger.out.json = format == "json"
+	defaultLogger.out.mu.Unlock()
+
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{defaultLogger})
+
+	return nil
+}
+
+// with returns a child logger which adds the key value pairs to every entry
+func (l *logger) with(kv ...interface{}) *logger {
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+
+	return &logger{out: l.out, fields: fields}
+}
+
+func (l *logger) debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l *logger) info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l *logger) warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l *logger) error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+func (l *logger) log(level logLevel, msg string, kv []interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level < l.out.level {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var b bytes.Buffer
+	if l.out.json {
+		b.WriteString(`{"time":`)
+		writeJSONValue(&b, now)
+		b.WriteString(`,"level":`)
+		writeJSONValue(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSONValue(&b, msg)
+		for i := 0; i+1 < len(fields); i += 2 {
+			b.WriteString(",")
+			writeJSONValue(&b, fmt.Sprint(fields[i]))
+			b.WriteString(":")
+			writeJSONValue(&b, logValue(fields[i+1]))
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%s %-5s %s", now, strings.ToUpper(level.String()), msg)
+		for i := 0; i+1 < len(fields); i += 2 {
+			fmt.Fprintf(&b, " %v=%s", fields[i], quoteLogValue(fmt.Sprint(logValue(fields[i+1]))))
+		}
+		b.WriteString("\n")
+	}
+
+	l.out.w.Write(b.Bytes())
+}
+
+// logValue turns errors into their message and keeps other values
+func logValue(v interface{}) interface{} {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+
+	return v
+}
+
+func writeJSONValue(b *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	b.Write(data)
+}
+
+// quoteLogValue quotes text values which contain spaces or quotes
+func quoteLogValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// stdLogWriter writes lines of the standard logger as info entries
+type stdLogWriter struct {
+	l *logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.l.info(strings.TrimSuffix(string(p), "\n"))
+
+	return len(p), nil
+}
+
+// maxRequestIDLength limits the length of request ids sent by clients
+const maxRequestIDLength = 64
+
+type loggerKey struct{}
+type requestIDKey struct{}
+
+// withRequestID returns a context carrying the request id and a logger
+// adding it to every entry
+func withRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+
+	return withLogger(ctx, loggerFrom(ctx).with("request_id", id))
+}
+
+// requestIDFrom returns the request id of ctx, if any
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+func withLogger(ctx context.Context, l *logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the logger of ctx or the default logger
+func loggerFrom(ctx context.Context) *logger {
+	if l, ok := ctx.Value(loggerKey{}).(*logger); ok {
+		return l
+	}
+
+	return defaultLogger
+}
+
+// newRequestID returns a random id to correlate the log entries of a request
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
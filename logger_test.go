@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_logger_log(t *testing.T) {
+	var b bytes.Buffer
+	l := &logger{out: &logOutput{w: &b, level: levelInfo}}
+
+	l.debug("hidden")
+	l.with("request_id", "abc").info("timer created", "job", "my job", "error", errors.New("broken"))
+
+	got := b.String()
+	if strings.Contains(got, "hidden") {
+		t.Errorf("debug entry written at info level: %s", got)
+	}
+	if want := `INFO  timer created request_id=abc job="my job" error=broken`; !strings.Contains(got, want) {
+		t.Errorf("text entry %q misses %q", got, want)
+	}
+
+	b.Reset()
+	l.out.json = true
+	l.with("request_id", "abc").warn("trigger failed", "status", 500)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid json entry %q: %v", b.String(), err)
+	}
+	if entry["level"] != "warn" || entry["msg"] != "trigger failed" || entry["request_id"] != "abc" || entry["status"] != float64(500) {
+		t.Errorf("json entry = %v", entry)
+	}
+}
+
+func Test_parseLogLevel(t *testing.T) {
+	if got, err := parseLogLevel("WARN"); err != nil || got != levelWarn {
+		t.Errorf("parseLogLevel(WARN) = %v, %v", got, err)
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel(verbose) accepted unknown level")
+	}
+}
+
+func Test_requestIDPropagation(t *testing.T) {
+	s := server{
+		mapping:        map[string][]string{"repo|master": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{QuietPeriod: 60}},
+	}
+	defer s.cancelJobs()
+
+	handler := instrument("/", s.handlePlainGet())
+	for _, id := range []string{"first", "second"} {
+		r := httptest.NewRequest("GET", "/?repo=repo&branch=master", nil)
+		r.Header.Set("X-Request-ID", id)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if got := w.Header().Get("X-Request-ID"); got != id {
+			t.Errorf("response request id = %q, want %q", got, id)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/?repo=other", nil))
+	if w.Header().Get("X-Request-ID") == "" {
+		t.Error("no request id generated")
+	}
+
+	s.timeKeeperLock.Lock()
+	pj := s.timeKeeper["job"]
+	s.timeKeeperLock.Unlock()
+
+	if got := strings.Join(pj.requestIDs, ","); got != "first,second" {
+		t.Errorf("pending job request ids = %q, want %q", got, "first,second")
+	}
+
+	var b bytes.Buffer
+	l := loggerFrom(pj.context())
+	l = &logger{out: &logOutput{w: &b}, fields: l.fields}
+	l.info("triggered")
+	if want := "job=job request_ids=first,second"; !strings.Contains(b.String(), want) {
+		t.Errorf("trigger entry %q misses %q", b.String(), want)
+	}
+
+	if got := requestIDFrom(withRequestID(context.Background(), "id")); got != "id" {
+		t.Errorf("requestIDFrom() = %q", got)
+	}
+}
@@ -3,109 +3,145 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
-	"crypto/tls"
 	"encoding/csv"
 	"encoding/hex"
 	"errors"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
 type mappingHandler interface {
 	hashSource() (string, error)
-	process(bool) (mapping, string, error)
+	process(bool) (mapping, map[string]time.Duration, string, error)
 }
 
 type mappingFile mappingSource
 type mappingURL mappingSource
 
-func (s *server) refreshMapping() error {
+const (
+	pushSection         = "push"
+	mergeRequestSection = "merge_request"
+	pullRequestSection  = "pull_request"
+	pollSection         = "poll"
+
+	anyBranch = "*" // matches every branch in merge and pull request sections
+)
+
+func (s *server) refreshMapping() (err error) {
+	defer func() {
+		s.health.recordRefresh(err)
+	}()
+
 	newHash, err := s.mappingSource.hashSource()
 	if err != nil {
+		metrics.inc(metricMappingReloads, "failure")
+
 		return err
 	}
 
 	if newHash != s.mappingHash {
 		if s.mappingHash != "" {
-			log.Printf("hash of mapping has changed (old: %s, new: %s)", s.mappingHash, newHash)
+			defaultLogger.info("hash of mapping has changed", "old", s.mappingHash, "new", newHash)
 		}
 
-		curMapping, curHash, err := s.mappingSource.process(s.param.proxy.FileMatching)
+		curMapping, curPolls, curHash, err := s.mappingSource.process(s.param.proxy.FileMatching)
 
 		if err != nil {
+			metrics.inc(metricMappingReloads, "failure")
+
 			return err
 		}
 		s.mapping = curMapping
+		s.polls = curPolls
 		s.mappingHash = curHash
+
+		metrics.inc(metricMappingReloads, "success")
+		metrics.setMappingHash(curHash)
 	}
 
 	return nil
 }
 
 // processMappingFile processes the file at given path
-func (m mappingFile) process(fileMatching bool) (mapping, string, error) {
-	log.Printf("reading mapping from file: %s\n", m.path)
+func (m mappingFile) process(fileMatching bool) (mapping, map[string]time.Duration, string, error) {
+	defaultLogger.info("reading mapping from file", "path", m.path)
 	var (
 		nm mapping
+		np map[string]time.Duration
 		nh string
 	)
 
 	file, err := os.Open(m.path)
 	if err != nil {
-		return nm, nh, err
+		return nm, np, nh, err
 	}
 	defer file.Close()
 
-	mapping, perr := parseMappingFile(file, fileMatching)
+	mapping, polls, perr := parseMappingFile(file, fileMatching)
 	if perr != nil {
-		return nm, nh, perr
+		return nm, np, nh, perr
 	}
 	newHash, herr := m.hashSource()
 	if herr != nil {
-		return nm, nh, herr
+		return nm, np, nh, herr
 	}
 
 	nm = mapping
+	np = polls
 	nh = newHash
 
-	return nm, nh, nil
+	return nm, np, nh, nil
 }
 
-func (m mappingURL) process(fileMatching bool) (mapping, string, error) {
-	log.Printf("reading mapping from url: %s\n", m.path)
+func (m mappingURL) process(fileMatching bool) (mapping, map[string]time.Duration, string, error) {
+	defaultLogger.info("reading mapping from url", "url", m.path)
 	var (
 		nm mapping
+		np map[string]time.Duration
 		nh string
 	)
-	body, err := httpGetWrapper(m.path)
+	body, err := httpGetWrapper(m.client, m.path)
 	if err != nil {
-		return nm, nh, err
+		return nm, np, nh, err
 	}
 
-	mapping, err := parseMappingFile(bytes.NewReader(body), fileMatching)
+	mapping, polls, err := parseMappingFile(bytes.NewReader(body), fileMatching)
 	if err != nil {
-		return nm, nh, err
+		return nm, np, nh, err
 	}
 	newHash, err := m.hashSource()
 	if err != nil {
-		return nm, nh, err
+		return nm, np, nh, err
 	}
 	nm = mapping
+	np = polls
 	nh = newHash
 
-	return nm, nh, nil
+	return nm, np, nh, nil
 }
 
-// parseMappingFile parses the given file and returns the mapping
-func parseMappingFile(file io.Reader, filematch bool) (map[string][]string, error) {
+// parseMappingFile parses the given file and returns the mapping and the
+// repos to poll
+//
+// Lines following a section header like "[merge_request]" are stored with
+// the section name as first part of their key. Lines before the first
+// header belong to the push section and are stored without prefix.
+// Merge and pull request lines carry the target branch in the second and
+// an optional source branch in the fourth field. Lines of the poll section
+// hold a repository and an optional poll interval instead of a job, they
+// are returned with the interval, zero if none is given.
+func parseMappingFile(file io.Reader, filematch bool) (map[string][]string, map[string]time.Duration, error) {
 	var m = make(map[string][]string)
+	var polls = make(map[string]time.Duration)
 
 	reader := csv.NewReader(file)
 	reader.Comma = ','
+	reader.FieldsPerRecord = -1
+	section := pushSection
 	lineCount := 0
 	for {
 		record, err := reader.Read()
@@ -113,25 +149,69 @@ func parseMappingFile(file io.Reader, filematch bool) (map[string][]string, erro
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return m, err
+			return m, polls, err
+		}
+
+		if len(record) == 1 && isSectionHeader(record[0]) {
+			header := strings.TrimSpace(record[0])
+			section = header[1 : len(header)-1]
+			if section != pushSection && section != mergeRequestSection && section != pullRequestSection && section != pollSection {
+				return m, polls, errors.New("unknown mapping section: " + section)
+			}
+			continue
+		}
+
+		if section == pollSection {
+			var interval time.Duration
+			if len(record) > 1 && strings.TrimSpace(record[1]) != "" {
+				if interval, err = time.ParseDuration(strings.TrimSpace(record[1])); err != nil {
+					return m, polls, err
+				}
+			}
+			polls[record[0]] = interval
+			lineCount++
+			continue
+		}
+
+		if len(record) < 3 {
+			return m, polls, errors.New("mapping line with less than three fields")
 		}
 
 		var key string
-		if filematch {
+		switch {
+		case section == mergeRequestSection || section == pullRequestSection:
+			key = buildMappingKey([]string{section, record[0], branchOrAny(record, 1), branchOrAny(record, 3)})
+		case filematch:
 			if len(record) != 4 {
-				return m, errors.New("no file matching information provided in mapping file")
+				return m, polls, errors.New("no file matching information provided in mapping file")
 			}
 			key = buildMappingKey([]string{record[0], record[1], record[3]})
-		} else {
+		default:
 			key = buildMappingKey([]string{record[0], record[1]})
 		}
 		m[key] = append(m[key], record[2])
 		lineCount++
 	}
 
-	log.Printf("successfully read mappings: %d\n", lineCount)
+	defaultLogger.info("successfully read mappings", "count", lineCount)
 
-	return m, nil
+	return m, polls, nil
+}
+
+// branchOrAny returns the branch in field i of record or anyBranch if the
+// field is missing or empty
+func branchOrAny(record []string, i int) string {
+	if len(record) <= i || strings.TrimSpace(record[i]) == "" {
+		return anyBranch
+	}
+
+	return record[i]
+}
+
+func isSectionHeader(field string) bool {
+	field = strings.TrimSpace(field)
+
+	return len(field) > 2 && strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]")
 }
 
 func (m mappingFile) hashSource() (string, error) {
@@ -154,7 +234,7 @@ func (m mappingFile) hashSource() (string, error) {
 
 func (m mappingURL) hashSource() (string, error) {
 	var mhash string
-	body, err := httpGetWrapper(m.path + ".sha256")
+	body, err := httpGetWrapper(m.client, m.path+".sha256")
 	if err != nil {
 		return mhash, err
 	}
@@ -164,7 +244,14 @@ func (m mappingURL) hashSource() (string, error) {
 	return mhash, err
 }
 
-func httpGetWrapper(url string) ([]byte, error) {
+// httpGetWrapper returns the body of url fetched with client, or with the
+// default client if client is nil
+func httpGetWrapper(client *http.Client, url string) ([]byte, error) {
+	return httpGetWithHeader(client, url, nil)
+}
+
+// httpGetWithHeader is like httpGetWrapper but adds the given header to the request
+func httpGetWithHeader(client *http.Client, url string, header http.Header) ([]byte, error) {
 	var rbody []byte
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -172,12 +259,14 @@ func httpGetWrapper(url string) ([]byte, error) {
 		return rbody, err
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	if client == nil {
+		client = defaultHTTPClient
 	}
 
-	timeout := time.Duration(5 * time.Second)
-	client := &http.Client{Transport: tr, Timeout: timeout}
 	resp, err := client.Do(req)
 
 	if err != nil {
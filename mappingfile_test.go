@@ -18,10 +18,11 @@ func Test_parseMappingFile(t *testing.T) {
 		filematch bool
 	}
 	tests := []struct {
-		name    string
-		args    args
-		want    map[string][]string
-		wantErr bool
+		name      string
+		args      args
+		want      map[string][]string
+		wantPolls map[string]time.Duration
+		wantErr   bool
 	}{
 		{
 			"single_repo",
@@ -29,6 +30,7 @@ func Test_parseMappingFile(t *testing.T) {
 			map[string][]string{
 				"git://repo/repo|branch": {"job"},
 			},
+			nil,
 			false,
 		},
 		{
@@ -37,12 +39,14 @@ func Test_parseMappingFile(t *testing.T) {
 			map[string][]string{
 				"git://repo/repo|branch|repo": {"job"},
 			},
+			nil,
 			false,
 		},
 		{
 			"single_repo_filematch_fail",
 			args{file: strings.NewReader("git://repo/repo,branch,job"), filematch: true},
 			map[string][]string{},
+			nil,
 			true,
 		},
 		{
@@ -52,12 +56,67 @@ func Test_parseMappingFile(t *testing.T) {
 				"git://repo/repo|branch":  {"job", "job2"},
 				"git://repo/repo2|branch": {"job"},
 			},
+			nil,
+			false,
+		},
+		{
+			"merge_request_section",
+			args{file: strings.NewReader("git://repo/repo,branch,job,repo\n[merge_request]\ngit://repo/repo,master,mrjob"), filematch: true},
+			map[string][]string{
+				"git://repo/repo|branch|repo":            {"job"},
+				"merge_request|git://repo/repo|master|*": {"mrjob"},
+			},
+			nil,
+			false,
+		},
+		{
+			"pull_request_section",
+			args{file: strings.NewReader("[pull_request]\ngit://repo/repo,master,prjob\ngit://repo/repo,*,prjob2,feature\ngit://repo/repo,,prjob3,"), filematch: false},
+			map[string][]string{
+				"pull_request|git://repo/repo|master|*":  {"prjob"},
+				"pull_request|git://repo/repo|*|feature": {"prjob2"},
+				"pull_request|git://repo/repo|*|*":       {"prjob3"},
+			},
+			nil,
 			false,
 		},
+		{
+			"poll_section",
+			args{file: strings.NewReader("git://repo/repo,master,job\n[poll]\ngit://repo/repo\ngit://repo/repo2,10m"), filematch: false},
+			map[string][]string{
+				"git://repo/repo|master": {"job"},
+			},
+			map[string]time.Duration{
+				"git://repo/repo":  0,
+				"git://repo/repo2": 10 * time.Minute,
+			},
+			false,
+		},
+		{
+			"poll_section_invalid_interval",
+			args{file: strings.NewReader("[poll]\ngit://repo/repo,often"), filematch: false},
+			map[string][]string{},
+			nil,
+			true,
+		},
+		{
+			"unknown_section",
+			args{file: strings.NewReader("[pull]\ngit://repo/repo,master,job"), filematch: false},
+			map[string][]string{},
+			nil,
+			true,
+		},
+		{
+			"too_few_fields",
+			args{file: strings.NewReader("git://repo/repo,master"), filematch: false},
+			map[string][]string{},
+			nil,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseMappingFile(tt.args.file, tt.args.filematch)
+			got, polls, err := parseMappingFile(tt.args.file, tt.args.filematch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseMappingFile() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -65,6 +124,9 @@ func Test_parseMappingFile(t *testing.T) {
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("parseMappingFile() = %v, want %v", got, tt.want)
 			}
+			if len(polls) != len(tt.wantPolls) || (len(polls) > 0 && !reflect.DeepEqual(polls, tt.wantPolls)) {
+				t.Errorf("parseMappingFile() polls = %v, want %v", polls, tt.wantPolls)
+			}
 		})
 	}
 }
@@ -113,7 +175,7 @@ func Test_server_process(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m, _, err := tt.s.mappingSource.process(tt.fm)
+			m, _, _, err := tt.s.mappingSource.process(tt.fm)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("mappingSource.process() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 )
 
 func (s *server) getHits(hits []string, key string) []string {
@@ -15,47 +19,240 @@ func (s *server) getHits(hits []string, key string) []string {
 	return hits
 }
 
-func (s *server) matchMappingKeys(keys []string, filematch bool) ([]string, error) {
+// lookupMappingKey returns the mapping key matching key and its jobs. With
+// file matching the longest key which is a prefix of key matches.
+func (s *server) lookupMappingKey(key string, filematch bool) (string, []string) {
+	if len(s.mapping[key]) > 0 {
+		return key, s.getHits(nil, key)
+	}
+
+	if filematch {
+		for len(key) > 1 {
+			key = removeLastRune(key)
+			if hits := s.getHits(nil, key); len(hits) > 0 {
+				return key, hits
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// errNoMappings is returned if no mapping matches the keys of an event
+var errNoMappings = errors.New("no mappings found")
+
+func (s *server) matchMappingKeys(ctx context.Context, keys []string, filematch bool) ([]string, error) {
+	jobs, _, err := s.matchMappingRows(ctx, keys, filematch)
+
+	return jobs, err
+}
+
+// matchMappingRows is like matchMappingKeys but returns the matched mapping
+// rows as well
+func (s *server) matchMappingRows(ctx context.Context, keys []string, filematch bool) ([]string, []auditMatch, error) {
+	l := loggerFrom(ctx)
+
 	var hits []string
+	matches := []auditMatch{}
 	for _, key := range keys {
-		log.Print("searching mappings for key: ", key)
-
-		if len(s.mapping[key]) > 0 {
-			hits = s.getHits(hits, key)
-		} else if filematch {
-			for len(key) > 1 {
-				key = removeLastRune(key)
-				oldHitCount := len(hits)
-				hits = s.getHits(hits, key)
-				if len(hits) > oldHitCount {
-					break
-				}
-			}
+		l.debug("searching mappings", "key", key)
+
+		match, jobs := s.lookupMappingKey(key, filematch)
+		if len(jobs) > 0 {
+			matches = append(matches, auditMatch{Key: key, Mapping: match, Jobs: jobs})
 		}
+		hits = append(hits, jobs...)
 	}
 
 	if len(hits) == 0 {
-		return []string{}, errors.New("no mappings found")
+		l.info("no mappings found", "keys", len(keys))
+		metrics.inc(metricMappingMisses)
+
+		return []string{}, matches, errNoMappings
+	}
+
+	metrics.inc(metricMappingMatches)
+
+	l.info("mappings found", "keys", len(keys), "jobs", len(hits))
+
+	return hits, matches, nil
+}
+
+// scheduleJobs creates a timer for every distinct job of repo. The event
+// describes what caused the jobs to be scheduled.
+func (s *server) scheduleJobs(ctx context.Context, repo string, jobs []string, params url.Values, event string) []scheduledJob {
+	scheduled := []scheduledJob{}
+	for _, job := range uniqueNonEmptyElementsOf(jobs) {
+		scheduled = append(scheduled, s.createTimer(ctx, repo, job, params, event))
+	}
+
+	return scheduled
+}
+
+// matchAndSchedule schedules the jobs of all mappings matching keys and
+// records the decision for repo and ref in the audit log
+func (s *server) matchAndSchedule(ctx context.Context, repo, ref string, keys []string, filematch bool, params url.Values, event string) ([]scheduledJob, error) {
+	rec := auditRecord{
+		Time:      time.Now(),
+		Type:      auditEventRecord,
+		RequestID: requestIDFrom(ctx),
+		Event:     auditEventFrom(ctx, repo, ref),
+	}
+
+	if c, ok := s.status.commit(repo, rec.Event.After); ok {
+		ctx = withStatusCommit(ctx, c)
+	}
+
+	jobs, matches, err := s.matchMappingRows(ctx, keys, filematch)
+	rec.Matches = matches
+
+	scheduled := []scheduledJob{}
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		scheduled = s.scheduleJobs(ctx, repo, jobs, params, event)
+		rec.Decisions = scheduled
 	}
 
-	log.Print("number of mappings found: ", len(hits))
+	s.audit.write(rec)
+
+	loggerFrom(ctx).debug("end processing mappings")
+
+	return scheduled, err
+}
 
-	return hits, nil
+func (s *server) processMatching(ctx context.Context, repo, branch string, files []string) ([]scheduledJob, error) {
+	return s.processMatchingWithParams(ctx, repo, branch, files, nil)
 }
 
-func (s *server) processMatching(repo, branch string, files []string) error {
+// processMatchingWithParams is like processMatching but hands params to
+// the triggered jobs
+func (s *server) processMatchingWithParams(ctx context.Context, repo, branch string, files []string, params url.Values) ([]scheduledJob, error) {
 	keys := evalMappingKeys(repo, branch, files, s.param.proxy.FileMatching, s.param.proxy.SemanticRepo)
 
-	jobs, err := s.matchMappingKeys(keys, s.param.proxy.FileMatching)
+	return s.matchAndSchedule(ctx, repo, branch, keys, s.param.proxy.FileMatching, params, repo+" "+branch)
+}
+
+// processRevisions is like processMatching but asks the git mirror for
+// the files changed between before and after, if file matching is enabled
+// and no files are given
+func (s *server) processRevisions(ctx context.Context, repo, branch string, files []string, before, after string, params url.Values) ([]scheduledJob, error) {
+	files, allFiles := s.resolveFiles(ctx, repo, files, before, after)
+	if allFiles {
+		return s.processBranch(ctx, repo, branch, params)
+	}
+
+	return s.processMatchingWithParams(ctx, repo, branch, files, params)
+}
+
+// processRefUpdate is like processRevisions for hosts which never send the
+// changed files of a ref update. With file matching and without git mirror
+// all mappings of the branch are used.
+func (s *server) processRefUpdate(ctx context.Context, repo, branch string, files []string, before, after string, params url.Values) ([]scheduledJob, error) {
+	if s.param.proxy.FileMatching && len(files) == 0 && s.mirror == nil {
+		loggerFrom(ctx).info("no changed files and no git mirror, using all mappings of the branch")
+
+		return s.processBranch(ctx, repo, branch, params)
+	}
+
+	return s.processRevisions(ctx, repo, branch, files, before, after, params)
+}
+
+// resolveFiles asks the git mirror for the files changed between before
+// and after, if file matching is enabled and no files are given. It reports
+// whether all mappings of the branch have to be used instead.
+func (s *server) resolveFiles(ctx context.Context, repo string, files []string, before, after string) ([]string, bool) {
+	if !s.param.proxy.FileMatching || len(files) > 0 || s.mirror == nil || after == "" {
+		return files, false
+	}
+
+	l := loggerFrom(ctx)
+
+	if !s.isMappedRepo(repo) {
+		l.info("no mapping of repo, not asking git mirror")
+
+		return files, false
+	}
+
+	l.info("no files in request, asking git mirror for changed files", "before", before, "after", after)
+
+	files, err := s.mirror.changedFiles(ctx, repo, before, after)
 	if err != nil {
-		return err
+		l.warn("falling back to all mappings of the branch", "error", err)
+
+		return files, true
+	}
+
+	return files, false
+}
+
+// isMappedRepo reports whether the mapping has push keys of repo. Only
+// those repos are mirrored, events of other repos don't match any key.
+func (s *server) isMappedRepo(repo string) bool {
+	prefix := buildMappingKey([]string{repo, ""})
+
+	for key := range s.mapping {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// branchKeys returns all mapping keys of files of repo and branch
+func (s *server) branchKeys(repo, branch string) []string {
+	prefix := buildMappingKey([]string{repo, branch, ""})
+
+	var keys []string
+	for key := range s.mapping {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// changeRequestKeys returns the mapping keys of a merge or pull request
+func changeRequestKeys(section, repo, target, source string) []string {
+	return []string{
+		buildMappingKey([]string{section, repo, target, source}),
+		buildMappingKey([]string{section, repo, target, anyBranch}),
+		buildMappingKey([]string{section, repo, anyBranch, source}),
+		buildMappingKey([]string{section, repo, anyBranch, anyBranch}),
 	}
+}
+
+// processBranch triggers every job mapped to any file of repo and branch.
+// It is used if the changed files of an event are unknown.
+func (s *server) processBranch(ctx context.Context, repo, branch string, params url.Values) ([]scheduledJob, error) {
+	return s.matchAndSchedule(ctx, repo, branch, s.branchKeys(repo, branch), false, params, repo+" "+branch)
+}
 
-	for _, job := range jobs {
-		s.createTimer(job)
+// processChangeRequest matches a merge or pull request against the given
+// mapping section. Mapping lines may leave the target or the source branch
+// open with anyBranch.
+func (s *server) processChangeRequest(ctx context.Context, section, repo, target, source string, params url.Values) ([]scheduledJob, error) {
+	keys := changeRequestKeys(section, repo, target, source)
+
+	return s.matchAndSchedule(ctx, repo, target, keys, false, params, section+" "+repo+" "+source+" -> "+target)
+}
+
+// acceptAction reports whether an event action is part of the accepted
+// actions. An empty list of accepted actions accepts every action.
+func acceptAction(accepted []string, action string) bool {
+	if len(accepted) == 0 {
+		return true
 	}
 
-	log.Print("end processing mappings")
+	for _, a := range accepted {
+		if a == action {
+			return true
+		}
+	}
 
-	return nil
+	return false
 }
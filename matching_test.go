@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
-	"time"
 )
 
 func Test_matchMappingKeysNoFileMatch(t *testing.T) {
@@ -22,8 +23,9 @@ func Test_matchMappingKeysNoFileMatch(t *testing.T) {
 		{
 			"simple",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 			},
 			args{keys: []string{"git://repo/repo|branch"}, filematch: false},
 			[]string{"job"},
@@ -32,8 +34,9 @@ func Test_matchMappingKeysNoFileMatch(t *testing.T) {
 		{
 			"no match",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 			},
 			args{keys: []string{"git://repo/repo2|branch"}, filematch: false},
 			[]string{},
@@ -42,7 +45,7 @@ func Test_matchMappingKeysNoFileMatch(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.server.matchMappingKeys(tt.args.keys, tt.args.filematch)
+			got, err := tt.server.matchMappingKeys(context.Background(), tt.args.keys, tt.args.filematch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("matchMappingKeys() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -69,8 +72,9 @@ func Test_matchMappingKeysFileMatch(t *testing.T) {
 		{
 			"simple_direct_hit",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch|cli": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch|cli": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 			},
 			args{keys: []string{"git://repo/repo|branch|cli"}, filematch: true},
 			[]string{"job"},
@@ -79,8 +83,9 @@ func Test_matchMappingKeysFileMatch(t *testing.T) {
 		{
 			"simple_indirect_hit",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch|cli": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch|cli": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 			},
 			args{keys: []string{"git://repo/repo|branch|cli/other"}, filematch: true},
 			[]string{"job"},
@@ -89,8 +94,9 @@ func Test_matchMappingKeysFileMatch(t *testing.T) {
 		{
 			"no match",
 			server{
-				mapping:    make(map[string][]string),
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        make(map[string][]string),
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 			},
 			args{keys: []string{"git://repo/repo2|branch|bla"}, filematch: true},
 			[]string{},
@@ -99,7 +105,7 @@ func Test_matchMappingKeysFileMatch(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.server.matchMappingKeys(tt.args.keys, tt.args.filematch)
+			got, err := tt.server.matchMappingKeys(context.Background(), tt.args.keys, tt.args.filematch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("matchMappingKeys() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -127,8 +133,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"simple_match",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch": {"job", "job2"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch": {"job", "job2"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -144,8 +151,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"simple_https_match",
 			server{
-				mapping:    map[string][]string{"https://repo/repo|branch": {"job", "job2"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"https://repo/repo|branch": {"job", "job2"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -161,8 +169,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"simple_ssh_match",
 			server{
-				mapping:    map[string][]string{"git@repo:repo|branch": {"job", "job2"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git@repo:repo|branch": {"job", "job2"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -178,8 +187,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"semantic_ssh_match",
 			server{
-				mapping:    map[string][]string{"git@repo:magic/repo|branch|repo/file": {"job", "job2"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git@repo:magic/repo|branch|repo/file": {"job", "job2"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -195,8 +205,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"simple_nomatch",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -212,8 +223,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"filematch_exact_match",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch|folder": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch|folder": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -229,8 +241,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"filematch_greedy_match",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch|folder": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch|folder": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -246,8 +259,9 @@ func Test_server_processMatching(t *testing.T) {
 		{
 			"filematch_no_match",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch|folder": {"job"}},
-				timeKeeper: make(map[string]*time.Timer),
+				mapping:        map[string][]string{"git://repo/repo|branch|folder": {"job"}},
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -267,7 +281,8 @@ func Test_server_processMatching(t *testing.T) {
 					"git://repo/repo|branch|folder":            {"job"},
 					"git://repo/magic/repo|branch|repo/folder": {"job2"},
 				},
-				timeKeeper: make(map[string]*time.Timer),
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -287,7 +302,8 @@ func Test_server_processMatching(t *testing.T) {
 					"git://repo/repo|branch|folder":            {"job"},
 					"git://repo/magic/repo|branch|repo/folder": {"job2"},
 				},
-				timeKeeper: make(map[string]*time.Timer),
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -307,7 +323,8 @@ func Test_server_processMatching(t *testing.T) {
 					"git://repo/repo|branch|folder":            {"job"},
 					"git://repo/magic/repo|branch|repo/folder": {"job2"},
 				},
-				timeKeeper: make(map[string]*time.Timer),
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
 				param: parameters{
 					proxy: proxy{
 						QuietPeriod:  5,
@@ -323,7 +340,7 @@ func Test_server_processMatching(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.s.processMatching(tt.args.repo, tt.args.branch, tt.args.files); (err != nil) != tt.wantErr {
+			if _, err := tt.s.processMatching(context.Background(), tt.args.repo, tt.args.branch, tt.args.files); (err != nil) != tt.wantErr {
 				t.Errorf("server.processMatching() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			got := make([]string, 0, len(tt.s.timeKeeper))
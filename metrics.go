@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricRequests       = "trigger_proxy_http_requests_total"
+	metricParseFailures  = "trigger_proxy_parse_failures_total"
+	metricMappingMatches = "trigger_proxy_mapping_matches_total"
+	metricMappingMisses  = "trigger_proxy_mapping_misses_total"
+	metricPendingTimers  = "trigger_proxy_pending_timers"
+	metricTimerResets    = "trigger_proxy_timer_resets_total"
+	metricPaused         = "trigger_proxy_paused"
+	metricTriggers       = "trigger_proxy_triggers_total"
+	metricTriggerLatency = "trigger_proxy_trigger_duration_seconds"
+	metricMappingReloads = "trigger_proxy_mapping_reloads_total"
+	metricMappingInfo    = "trigger_proxy_mapping_info"
+	metricBuilds         = "trigger_proxy_builds_total"
+	metricBuildResults   = "trigger_proxy_build_results_total"
+	metricQueueWait      = "trigger_proxy_queue_wait_seconds"
+)
+
+// triggerLatencyBuckets are the upper bounds of the trigger latency histogram
+var triggerLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// queueWaitBuckets are the upper bounds of the queue wait histogram
+var queueWaitBuckets = []float64{1, 5, 10, 30, 60, 300, 600}
+
+// metricFamily is a metric with all its label combinations
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	samples map[string]*metricSample
+}
+
+// metricSample is a metric family with fixed label values
+type metricSample struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+// metricsRegistry holds the metrics exposed at /metrics
+type metricsRegistry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+// metrics is the registry of the proxy
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	m := &metricsRegistry{families: make(map[string]*metricFamily)}
+
+	m.register(metricRequests, "counter", "Number of http requests per endpoint and status code.", nil, "endpoint", "code")
+	m.register(metricParseFailures, "counter", "Number of requests which couldn't be parsed per provider.", nil, "provider")
+	m.register(metricMappingMatches, "counter", "Number of events which matched at least one mapping.", nil)
+	m.register(metricMappingMisses, "counter", "Number of events which matched no mapping.", nil)
+	m.register(metricPendingTimers, "gauge", "Number of jobs waiting for their quiet period to pass.", nil)
+	m.register(metricTimerResets, "counter", "Number of quiet periods restarted by a new event.", nil)
+	m.register(metricPaused, "gauge", "Whether triggering is paused.", nil)
+	m.register(metricTriggers, "counter", "Number of jenkins triggers per job and result.", nil, "job", "result")
+	m.register(metricTriggerLatency, "histogram", "Duration of jenkins trigger requests in seconds.", triggerLatencyBuckets, "result")
+	m.register(metricMappingReloads, "counter", "Number of mapping reloads per result.", nil, "result")
+	m.register(metricMappingInfo, "gauge", "Hash of the current mapping.", nil, "hash")
+	m.register(metricBuilds, "counter", "Number of triggered builds per job and state reached in jenkins.", nil, "job", "state")
+	m.register(metricBuildResults, "counter", "Number of finished builds per job and result.", nil, "job", "result")
+	m.register(metricQueueWait, "histogram", "Time from the trigger until the build started in seconds.", queueWaitBuckets)
+
+	return m
+}
+
+func (m *metricsRegistry) register(name, kind, help string, buckets []float64, labels ...string) {
+	m.families[name] = &metricFamily{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		samples: make(map[string]*metricSample),
+	}
+}
+
+// sample returns the sample of the family name with the given label
+// values, the caller has to hold the lock
+func (m *metricsRegistry) sample(name string, labelValues []string) *metricSample {
+	f := m.families[name]
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.samples[key]
+	if !ok {
+		s = &metricSample{labelValues: labelValues, counts: make([]uint64, len(f.buckets))}
+		f.samples[key] = s
+	}
+
+	return s
+}
+
+// inc increments the counter name
+func (m *metricsRegistry) inc(name string, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sample(name, labelValues).value++
+}
+
+// set sets the gauge name
+func (m *metricsRegistry) set(name string, value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sample(name, labelValues).value = value
+}
+
+// observe adds value to the histogram name
+func (m *metricsRegistry) observe(name string, value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.families[name]
+	s := m.sample(name, labelValues)
+	for i, bound := range f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+// setMappingHash replaces the hash of the mapping info metric
+func (m *metricsRegistry) setMappingHash(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.families[metricMappingInfo].samples = make(map[string]*metricSample)
+	m.sample(metricMappingInfo, []string{hash}).value = 1
+}
+
+// write writes all metrics in the prometheus text format
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+		var keys []string
+		for key := range f.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if len(keys) == 0 && len(f.labels) == 0 && f.kind != "histogram" {
+			fmt.Fprintf(w, "%s 0\n", f.name)
+		}
+
+		for _, key := range keys {
+			s := f.samples[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatValue(s.value))
+
+				continue
+			}
+
+			names := append(append([]string{}, f.labels...), "le")
+			for i, bound := range f.buckets {
+				labels := formatLabels(names, append(append([]string{}, s.labelValues...), formatValue(bound)))
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels, s.counts[i])
+			}
+			labels := formatLabels(names, append(append([]string{}, s.labelValues...), "+Inf"))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels, s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatValue(s.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues), s.count)
+		}
+	}
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats label names and values like {name="value"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrument counts the requests handled by h per status code. Every
+// request gets an id, taken from the X-Request-ID header if sent, which is
+// added to the log entries of the request.
+func instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := withRequestID(r.Context(), id)
+		l := loggerFrom(ctx)
+		l.debug("request received", "method", r.Method, "path", r.URL.Path)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(ctx))
+
+		l.info("request handled", "endpoint", endpoint, "status", rec.status, "duration", time.Since(start).String())
+		metrics.inc(metricRequests, endpoint, strconv.Itoa(rec.status))
+	}
+}
+
+// observeTrigger records the result and duration of a jenkins trigger
+func observeTrigger(job, result string, start time.Time) {
+	metrics.inc(metricTriggers, job, result)
+	metrics.observe(metricTriggerLatency, time.Since(start).Seconds(), result)
+}
+
+// handleMetrics serves the metrics in the prometheus text format
+func (s *server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.timeKeeperLock.Lock()
+		pending := len(s.timeKeeper)
+		paused := 0
+		if s.paused {
+			paused = 1
+		}
+		s.timeKeeperLock.Unlock()
+
+		metrics.set(metricPendingTimers, float64(pending))
+		metrics.set(metricPaused, float64(paused))
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_metricsRegistry_write(t *testing.T) {
+	m := newMetricsRegistry()
+	m.inc(metricRequests, "/json", "202")
+	m.inc(metricRequests, "/json", "202")
+	m.inc(metricParseFailures, `git"lab`)
+	m.observe(metricTriggerLatency, 0.3, "success")
+	m.setMappingHash("old")
+	m.setMappingHash("new")
+
+	var b bytes.Buffer
+	m.write(&b)
+	got := b.String()
+
+	for _, want := range []string{
+		"# TYPE trigger_proxy_http_requests_total counter\n",
+		`trigger_proxy_http_requests_total{endpoint="/json",code="202"} 2` + "\n",
+		`trigger_proxy_parse_failures_total{provider="git\"lab"} 1` + "\n",
+		"trigger_proxy_mapping_misses_total 0\n",
+		"# TYPE trigger_proxy_trigger_duration_seconds histogram\n",
+		`trigger_proxy_trigger_duration_seconds_bucket{result="success",le="0.25"} 0` + "\n",
+		`trigger_proxy_trigger_duration_seconds_bucket{result="success",le="0.5"} 1` + "\n",
+		`trigger_proxy_trigger_duration_seconds_bucket{result="success",le="+Inf"} 1` + "\n",
+		`trigger_proxy_trigger_duration_seconds_sum{result="success"} 0.3` + "\n",
+		`trigger_proxy_trigger_duration_seconds_count{result="success"} 1` + "\n",
+		`trigger_proxy_mapping_info{hash="new"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics miss %q in:\n%s", want, got)
+		}
+	}
+
+	if strings.Contains(got, `hash="old"`) {
+		t.Errorf("metrics still contain the old mapping hash:\n%s", got)
+	}
+}
+
+func Test_instrument(t *testing.T) {
+	handler := instrument("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	var b bytes.Buffer
+	metrics.write(&b)
+
+	if want := `trigger_proxy_http_requests_total{endpoint="/test",code="418"} 1`; !strings.Contains(b.String(), want) {
+		t.Errorf("metrics miss %q", want)
+	}
+}
+
+func Test_server_handleMetrics(t *testing.T) {
+	s := server{
+		timeKeeper:     map[string]*pendingJob{"job1": {job: "job1"}, "job2": {job: "job2"}},
+		timeKeeperLock: new(sync.Mutex),
+	}
+
+	w := httptest.NewRecorder()
+	s.handleMetrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("handler returned content type %q", w.Header().Get("Content-Type"))
+	}
+	if want := "trigger_proxy_pending_timers 2\n"; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("metrics miss %q in:\n%s", want, w.Body.String())
+	}
+}
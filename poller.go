@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pollTick = 10 * time.Second // interval to check for repos due to be polled
+
+// poller periodically compares the branch heads of repositories listed in
+// the poll section of the mapping with the heads seen before
+type poller struct {
+	path     string
+	interval time.Duration
+	mu       sync.Mutex
+	heads    map[string]map[string]string
+	lastPoll map[string]time.Time
+}
+
+func newPoller(path string, interval time.Duration) (*poller, error) {
+	p := &poller{
+		path:     path,
+		interval: interval,
+		heads:    make(map[string]map[string]string),
+		lastPoll: make(map[string]time.Time),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return p, err
+	}
+
+	if err := json.Unmarshal(data, &p.heads); err != nil {
+		return p, err
+	}
+
+	defaultLogger.info("loaded poll state", "repos", len(p.heads), "path", path)
+
+	return p, nil
+}
+
+// save writes the known heads to disk
+func (p *poller) save() error {
+	data, err := json.MarshalIndent(p.heads, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, p.path)
+}
+
+// pollRepos returns the repos of the poll section with their poll
+// interval, the default interval if the mapping sets none
+func (s *server) pollRepos() map[string]time.Duration {
+	repos := make(map[string]time.Duration, len(s.polls))
+
+	for repo, interval := range s.polls {
+		if interval == 0 {
+			interval = s.poller.interval
+		}
+
+		repos[repo] = interval
+	}
+
+	return repos
+}
+
+// pollDue polls every repo whose poll interval has passed
+func (s *server) pollDue(now time.Time) {
+	for repo, interval := range s.pollRepos() {
+		s.poller.mu.Lock()
+		due := now.Sub(s.poller.lastPoll[repo]) >= interval
+		if due {
+			s.poller.lastPoll[repo] = now
+		}
+		s.poller.mu.Unlock()
+
+		if due {
+			if err := s.pollRepo(repo); err != nil {
+				defaultLogger.warn("polling repo failed", "repo", repo, "error", err)
+			}
+		}
+	}
+}
+
+// pollRepo lists the branch heads of repo and processes every branch whose
+// head has changed since the last poll. On the first poll of a repo the
+// heads are only recorded.
+func (s *server) pollRepo(repo string) error {
+	defaultLogger.info("polling repo", "repo", repo)
+
+	heads, err := lsRemoteHeads(repo)
+	if err != nil {
+		return err
+	}
+
+	s.poller.mu.Lock()
+	known, seen := s.poller.heads[repo]
+	s.poller.heads[repo] = heads
+	err = s.poller.save()
+	s.poller.mu.Unlock()
+
+	if err != nil {
+		defaultLogger.error("saving poll state failed", "error", err)
+	}
+
+	if !seen {
+		defaultLogger.info("recorded branch heads", "repo", repo, "branches", len(heads))
+
+		return nil
+	}
+
+	for branch, head := range heads {
+		before := known[branch]
+		if before == head {
+			continue
+		}
+
+		ctx := withRequestID(context.Background(), newRequestID())
+		ctx = withAuditEvent(ctx, auditEvent{Provider: "poll", Before: before, After: head})
+		loggerFrom(ctx).info("polled branch changed", "repo", repo, "branch", branch, "before", before, "after", head)
+
+		if _, err := s.processPolledChange(ctx, repo, branch, before, head); err != nil {
+			loggerFrom(ctx).info("processing polled change failed", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// processPolledChange hands a detected branch change to the matching. With
+// file matching the changed files are computed by the git mirror, if one is
+// configured, otherwise all mappings of the branch are processed.
+func (s *server) processPolledChange(ctx context.Context, repo, branch, before, after string) ([]scheduledJob, error) {
+	if !s.param.proxy.FileMatching {
+		return s.processMatching(ctx, repo, branch, []string{})
+	}
+
+	if s.mirror == nil {
+		return s.processBranch(ctx, repo, branch, nil)
+	}
+
+	files, err := s.mirror.changedFiles(ctx, repo, before, after)
+	if err != nil {
+		loggerFrom(ctx).warn("falling back to all mappings of the branch", "error", err)
+
+		return s.processBranch(ctx, repo, branch, nil)
+	}
+
+	return s.processMatching(ctx, repo, branch, files)
+}
+
+// lsRemoteHeads returns the heads of all branches of repo
+func lsRemoteHeads(repo string) (map[string]string, error) {
+	heads := make(map[string]string)
+
+	out, err := runGit("", "ls-remote", "--heads", "--", repo)
+	if err != nil {
+		return heads, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		heads[strings.TrimPrefix(fields[1], "refs/heads/")] = fields[0]
+	}
+
+	if len(heads) == 0 {
+		return heads, errors.New("no branches found in " + repo)
+	}
+
+	return heads, nil
+}
+
+func (s *server) createPollJob() {
+	ticker := time.NewTicker(pollTick)
+	go func() {
+		for now := range ticker.C {
+			s.pollDue(now)
+		}
+	}()
+}
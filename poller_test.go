@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_server_pollRepos(t *testing.T) {
+	s := server{
+		polls: map[string]time.Duration{
+			"git://repo/a": 0,
+			"git://repo/b": 30 * time.Second,
+		},
+		poller: &poller{interval: time.Minute},
+	}
+
+	want := map[string]time.Duration{
+		"git://repo/a": time.Minute,
+		"git://repo/b": 30 * time.Second,
+	}
+	if got := s.pollRepos(); !reflect.DeepEqual(got, want) {
+		t.Errorf("pollRepos() = %v, want %v", got, want)
+	}
+}
+
+func Test_server_pollRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trigger-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, _, _ := createTestRepo(t, dir)
+	origin := strings.TrimPrefix(repo, "file://")
+	state := filepath.Join(dir, "poll-state.json")
+
+	p, err := newPoller(state, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := server{
+		polls: map[string]time.Duration{repo: 0},
+		mapping: map[string][]string{
+			repo + "|master":    {"job1"},
+			repo + "|feature":   {"job2"},
+			repo + "|unchanged": {"job3"},
+		},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		poller:         p,
+		param:          parameters{proxy: proxy{QuietPeriod: 5}},
+	}
+
+	testGit(t, origin, "branch", "unchanged")
+
+	// the first poll only records the heads
+	if err := s.pollRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.timeKeeper) != 0 {
+		t.Errorf("first poll scheduled jobs: %v", s.timeKeeper)
+	}
+
+	commitTestFiles(t, origin, "sub3/c")
+	testGit(t, origin, "branch", "feature")
+
+	// reload the state from disk to verify it survives a restart
+	s.poller, err = newPoller(state, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.pollRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.timeKeeper) != 2 || s.timeKeeper["job1"] == nil || s.timeKeeper["job2"] == nil {
+		t.Errorf("second poll scheduled wrong jobs: %v", s.timeKeeper)
+	}
+	for _, pj := range s.timeKeeper {
+		pj.timer.Stop()
+	}
+}
@@ -4,42 +4,91 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 )
 
+// pushEvent holds the relevant parts of a GitLab push event
+type pushEvent struct {
+	Repos     []string
+	Branch    string
+	Files     []string
+	Before    string
+	After     string
+	ProjectID int
+	Pusher    string
+	Commits   []commit
+	// Truncated is set if GitLab left out commits of the push
+	Truncated bool
+}
+
+// hookEvent is the event sent by the hook command
+type hookEvent struct {
+	Repo   string   `json:"repo"`
+	Branch string   `json:"branch"`
+	Before string   `json:"before,omitempty"`
+	After  string   `json:"after,omitempty"`
+	Files  []string `json:"files,omitempty"`
+}
+
+// mergeRequest holds the relevant parts of a GitLab merge request event
+type mergeRequest struct {
+	Repos        []string
+	SourceBranch string
+	TargetBranch string
+	IID          int
+	Action       string
+	// SHA is the last commit of the source branch
+	SHA string
+	// OldRev is the previous last commit, GitLab only sends it if an
+	// update pushed new commits
+	OldRev string
+}
+
+// pullRequest holds the relevant parts of a GitHub pull request event
+type pullRequest struct {
+	Repos      []string
+	BaseBranch string
+	HeadBranch string
+	Number     int
+	Action     string
+	Draft      bool
+	// SHA is the head commit of the pull request
+	SHA string
+}
+
 func parseGetRequest(r *http.Request, filematch bool) (string, string, []string, error) {
 	repo := ""
 	branch := ""
 	files := []string{}
 
-	log.Print("parsing get request")
+	l := loggerFrom(r.Context())
+
+	l.info("parsing get request")
 	reqRepo, ok := r.URL.Query()["repo"]
 
 	if !ok || len(reqRepo) < 1 {
-		log.Print("repo is missing")
-		log.Print("aborting request handling")
-
 		return repo, branch, files, errors.New("repo is missing")
 	}
 
 	repo = reqRepo[0]
 
-	log.Print("parsed repo: ", repo)
+	l.info("parsed repo", "repo", repo)
 
 	reqBranch, ok := r.URL.Query()["branch"]
 
 	if !ok || len(reqBranch) < 1 {
-		log.Print("branch is missing. Assuming master")
+		l.info("branch is missing, assuming master")
 
 		branch = "master"
 	} else {
 		branch = reqBranch[0]
 	}
 
-	log.Print("parsed branch: ", branch)
+	l.info("parsed branch", "branch", branch)
 
 	if filematch {
 		reqFiles, ok := r.URL.Query()["files"]
@@ -52,50 +101,73 @@ func parseGetRequest(r *http.Request, filematch bool) (string, string, []string,
 	return repo, branch, files, nil
 }
 
-func parseJSONRequest(r *http.Request, filematch bool) ([]string, string, []string, error) {
-	repo := []string{}
-	branch := "master"
-	files := []string{}
+// parseGetRevisions returns the optional before and after commits of a get request
+func parseGetRevisions(r *http.Request) (string, string) {
+	return r.URL.Query().Get("before"), r.URL.Query().Get("after")
+}
+
+func parseJSONRequest(r *http.Request, filematch bool) (pushEvent, error) {
+	push := pushEvent{
+		Repos:  []string{},
+		Branch: "master",
+		Files:  []string{},
+	}
 
 	type gitlabProject struct {
+		ID         int
 		Gitsshurl  string `json:"git_ssh_url"`
 		Githttpurl string `json:"git_http_url"`
 	}
 
+	type gitlabAuthor struct {
+		Name  string
+		Email string
+	}
+
 	type gitlabCommit struct {
+		Message  string
+		Author   gitlabAuthor
 		Added    []string
 		Modified []string
 		Removed  []string
 	}
 
 	type gitlabWebhook struct {
-		Ref     string
-		Project gitlabProject
-		Commits []gitlabCommit
+		Ref               string
+		Before            string
+		After             string
+		UserUsername      string `json:"user_username"`
+		Project           gitlabProject
+		Commits           []gitlabCommit
+		TotalCommitsCount int `json:"total_commits_count"`
 	}
 
-	log.Print("parsing json request")
+	loggerFrom(r.Context()).info("parsing json request")
 
 	var h gitlabWebhook
 
 	body, _ := ioutil.ReadAll(r.Body)
 	err := json.Unmarshal(body, &h)
 	if err != nil {
-		return repo, branch, files, errors.New("bad request")
+		return push, errors.New("bad request")
 	}
 
 	if h.Project.Githttpurl != "" {
-		repo = append(repo, h.Project.Githttpurl)
+		push.Repos = append(push.Repos, h.Project.Githttpurl)
 	}
 	if h.Project.Gitsshurl != "" {
-		repo = append(repo, h.Project.Gitsshurl)
+		push.Repos = append(push.Repos, h.Project.Gitsshurl)
 	}
 
 	if strings.Contains(h.Ref, "refs/heads/") {
-		branch = strings.ReplaceAll(h.Ref, "refs/heads/", "")
+		push.Branch = strings.ReplaceAll(h.Ref, "refs/heads/", "")
 	}
 
+	files := []string{}
+	push.Commits = []commit{}
 	for _, commit := range h.Commits {
+		push.Commits = append(push.Commits, newCommit(commit.Message, commit.Author.Name, commit.Author.Email))
+
 		for _, file := range commit.Added {
 			files = append(files, file)
 		}
@@ -111,5 +183,204 @@ func parseJSONRequest(r *http.Request, filematch bool) ([]string, string, []stri
 
 	sort.Strings(files)
 
-	return repo, branch, files, nil
+	push.Files = files
+	push.Before = h.Before
+	push.After = h.After
+	push.ProjectID = h.Project.ID
+	push.Pusher = h.UserUsername
+	push.Truncated = h.TotalCommitsCount > len(h.Commits)
+
+	return push, nil
+}
+
+func parseEventRequest(r *http.Request) (hookEvent, error) {
+	var ev hookEvent
+
+	l := loggerFrom(r.Context())
+
+	l.info("parsing event")
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return ev, errors.New("bad request")
+	}
+
+	if ev.Repo == "" {
+		return ev, errors.New("repo is missing")
+	}
+
+	if ev.Branch == "" {
+		l.info("branch is missing, assuming master")
+
+		ev.Branch = "master"
+	}
+
+	ev.Files = uniqueNonEmptyElementsOf(ev.Files)
+
+	sort.Strings(ev.Files)
+
+	l.info("parsed event", "repo", ev.Repo, "branch", ev.Branch, "files", len(ev.Files))
+
+	return ev, nil
+}
+
+func parseMergeRequest(r *http.Request) (mergeRequest, error) {
+	mr := mergeRequest{Repos: []string{}}
+
+	type gitlabProject struct {
+		Gitsshurl  string `json:"git_ssh_url"`
+		Githttpurl string `json:"git_http_url"`
+	}
+
+	type gitlabLastCommit struct {
+		ID string
+	}
+
+	type gitlabMergeRequestAttributes struct {
+		IID          int `json:"iid"`
+		Action       string
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		Source       gitlabProject
+		Target       gitlabProject
+		LastCommit   gitlabLastCommit `json:"last_commit"`
+		OldRev       string           `json:"oldrev"`
+	}
+
+	type gitlabMergeRequestHook struct {
+		ObjectKind       string                       `json:"object_kind"`
+		ObjectAttributes gitlabMergeRequestAttributes `json:"object_attributes"`
+	}
+
+	l := loggerFrom(r.Context())
+
+	l.info("parsing merge request")
+
+	var h gitlabMergeRequestHook
+
+	body, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(body, &h)
+	if err != nil || h.ObjectKind != "merge_request" {
+		return mr, errors.New("bad request")
+	}
+
+	attr := h.ObjectAttributes
+
+	if attr.Target.Githttpurl != "" {
+		mr.Repos = append(mr.Repos, attr.Target.Githttpurl)
+	}
+	if attr.Target.Gitsshurl != "" {
+		mr.Repos = append(mr.Repos, attr.Target.Gitsshurl)
+	}
+
+	if len(mr.Repos) == 0 || attr.TargetBranch == "" {
+		return mr, errors.New("merge request without target")
+	}
+
+	mr.SourceBranch = attr.SourceBranch
+	mr.TargetBranch = attr.TargetBranch
+	mr.IID = attr.IID
+	mr.Action = attr.Action
+	mr.SHA = attr.LastCommit.ID
+	mr.OldRev = attr.OldRev
+
+	l.info("parsed merge request", "iid", mr.IID, "action", mr.Action, "source_branch", mr.SourceBranch, "target_branch", mr.TargetBranch)
+
+	return mr, nil
+}
+
+// skipReason returns why the merge request doesn't trigger jobs, or an
+// empty string. Updates without new commits, e.g. of the title or the
+// labels, are skipped.
+func (mr mergeRequest) skipReason(actions []string) string {
+	if !acceptAction(actions, mr.Action) {
+		return "ignored merge request action: " + mr.Action
+	}
+
+	if mr.Action == "update" && mr.OldRev == "" {
+		return "merge request update without new commits"
+	}
+
+	return ""
+}
+
+// jobParameters returns the parameters handed to jenkins for a merge request
+func (mr mergeRequest) jobParameters() url.Values {
+	return url.Values{
+		"MR_IID":           {strconv.Itoa(mr.IID)},
+		"MR_SOURCE_BRANCH": {mr.SourceBranch},
+		"MR_TARGET_BRANCH": {mr.TargetBranch},
+	}
+}
+
+func parsePullRequest(r *http.Request) (pullRequest, error) {
+	pr := pullRequest{Repos: []string{}}
+
+	type githubRepository struct {
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+	}
+
+	type githubBranch struct {
+		Ref  string
+		SHA  string
+		Repo githubRepository
+	}
+
+	type githubPullRequest struct {
+		Draft bool
+		Base  githubBranch
+		Head  githubBranch
+	}
+
+	type githubPullRequestEvent struct {
+		Action      string
+		Number      int
+		PullRequest *githubPullRequest `json:"pull_request"`
+	}
+
+	l := loggerFrom(r.Context())
+
+	l.info("parsing pull request")
+
+	var e githubPullRequestEvent
+
+	body, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(body, &e)
+	if err != nil || e.PullRequest == nil {
+		return pr, errors.New("bad request")
+	}
+
+	base := e.PullRequest.Base
+
+	if base.Repo.CloneURL != "" {
+		pr.Repos = append(pr.Repos, base.Repo.CloneURL)
+	}
+	if base.Repo.SSHURL != "" {
+		pr.Repos = append(pr.Repos, base.Repo.SSHURL)
+	}
+
+	if len(pr.Repos) == 0 || base.Ref == "" {
+		return pr, errors.New("pull request without base")
+	}
+
+	pr.BaseBranch = base.Ref
+	pr.HeadBranch = e.PullRequest.Head.Ref
+	pr.Number = e.Number
+	pr.Action = e.Action
+	pr.Draft = e.PullRequest.Draft
+	pr.SHA = e.PullRequest.Head.SHA
+
+	l.info("parsed pull request", "number", pr.Number, "action", pr.Action, "head_branch", pr.HeadBranch, "base_branch", pr.BaseBranch)
+
+	return pr, nil
+}
+
+// jobParameters returns the parameters handed to jenkins for a pull request
+func (pr pullRequest) jobParameters() url.Values {
+	return url.Values{
+		"PR_NUMBER":      {strconv.Itoa(pr.Number)},
+		"PR_BASE_BRANCH": {pr.BaseBranch},
+		"PR_HEAD_BRANCH": {pr.HeadBranch},
+	}
 }
@@ -179,6 +179,7 @@ func Test_parseJSONRequest(t *testing.T) {
 		want    []string
 		want1   string
 		want2   []string
+		want3   pushEvent
 		wantErr bool
 	}{
 		{
@@ -187,24 +188,47 @@ func Test_parseJSONRequest(t *testing.T) {
 			[]string{"http://example.com/mike/diaspora.git", "git@example.com:mike/diaspora.git"},
 			"master",
 			[]string{"CHANGELOG", "README.md", "app/controller/application.rb"},
+			pushEvent{
+				Before:    "95790bf891e76fee5e1747ab589903a6a1f80f22",
+				After:     "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				ProjectID: 15,
+				Pusher:    "jsmith",
+				Commits: []commit{
+					{
+						Message:     "Update Catalan translation to e38cb41.\n\nSee https://gitlab.com/gitlab-org/gitlab for more information",
+						AuthorName:  "Jordi Mallach",
+						AuthorEmail: "jordi@softcatala.org",
+					},
+					{
+						Message:     "fixed readme",
+						AuthorName:  "GitLab dev user",
+						AuthorEmail: "gitlabdev@dv6700.(none)",
+					},
+				},
+				Truncated: true,
+			},
 			false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, got2, err := parseJSONRequest(tt.args.r, tt.args.filematch)
+			got, err := parseJSONRequest(tt.args.r, tt.args.filematch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseJSONRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parseJSONRequest() got = %v, want %v", got, tt.want)
+			if !reflect.DeepEqual(got.Repos, tt.want) {
+				t.Errorf("parseJSONRequest() got = %v, want %v", got.Repos, tt.want)
 			}
-			if got1 != tt.want1 {
-				t.Errorf("parseJSONRequest() got1 = %v, want %v", got1, tt.want1)
+			if got.Branch != tt.want1 {
+				t.Errorf("parseJSONRequest() got1 = %v, want %v", got.Branch, tt.want1)
 			}
-			if !reflect.DeepEqual(got2, tt.want2) {
-				t.Errorf("parseJSONRequest() got2 = %v, want %v", got2, tt.want2)
+			if !reflect.DeepEqual(got.Files, tt.want2) {
+				t.Errorf("parseJSONRequest() got2 = %v, want %v", got.Files, tt.want2)
+			}
+			got.Repos, got.Branch, got.Files = nil, "", nil
+			if !reflect.DeepEqual(got, tt.want3) {
+				t.Errorf("parseJSONRequest() got3 = %+v, want %+v", got, tt.want3)
 			}
 		})
 	}
@@ -270,19 +294,219 @@ func Test_parseJSONRequestSynthetic(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, got2, err := parseJSONRequest(tt.args.r, tt.args.filematch)
+			got, err := parseJSONRequest(tt.args.r, tt.args.filematch)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseJSONRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if !reflect.DeepEqual(got.Repos, tt.want) {
+				t.Errorf("parseJSONRequest() got = %v, want %v", got.Repos, tt.want)
+			}
+			if got.Branch != tt.want1 {
+				t.Errorf("parseJSONRequest() got1 = %v, want %v", got.Branch, tt.want1)
+			}
+			if !reflect.DeepEqual(got.Files, tt.want2) {
+				t.Errorf("parseJSONRequest() got2 = %v, want %v", got.Files, tt.want2)
+			}
+		})
+	}
+}
+
+func Test_parseMergeRequest(t *testing.T) {
+	body := `{
+		"object_kind": "merge_request",
+		"event_type": "merge_request",
+		"user": {
+		  "name": "Administrator",
+		  "username": "root"
+		},
+		"project": {
+		  "id": 1,
+		  "git_ssh_url":"git@example.com:gitlabhq/gitlab-test.git",
+		  "git_http_url":"http://example.com/gitlabhq/gitlab-test.git"
+		},
+		"object_attributes": {
+		  "id": 99,
+		  "iid": 1,
+		  "target_branch": "master",
+		  "source_branch": "ms-viewport",
+		  "source_project_id": 14,
+		  "target_project_id": 14,
+		  "title": "MS-Viewport",
+		  "state": "opened",
+		  "action": "open",
+		  "last_commit": {
+			"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+		  },
+		  "source": {
+			"git_ssh_url":"git@example.com:awesome_space/awesome_project.git",
+			"git_http_url":"http://example.com/awesome_space/awesome_project.git"
+		  },
+		  "target": {
+			"git_ssh_url":"git@example.com:gitlabhq/gitlab-test.git",
+			"git_http_url":"http://example.com/gitlabhq/gitlab-test.git"
+		  }
+		}
+	  }`
+	tests := []struct {
+		name    string
+		body    string
+		want    mergeRequest
+		wantErr bool
+	}{
+		{
+			"open",
+			body,
+			mergeRequest{
+				Repos:        []string{"http://example.com/gitlabhq/gitlab-test.git", "git@example.com:gitlabhq/gitlab-test.git"},
+				SourceBranch: "ms-viewport",
+				TargetBranch: "master",
+				IID:          1,
+				Action:       "open",
+				SHA:          "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+			},
+			false,
+		},
+		{
+			"push_event",
+			`{"object_kind": "push", "ref": "refs/heads/master"}`,
+			mergeRequest{Repos: []string{}},
+			true,
+		},
+		{
+			"invalid_json",
+			`{`,
+			mergeRequest{Repos: []string{}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "/json", strings.NewReader(tt.body))
+			got, err := parseMergeRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseMergeRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parseJSONRequest() got = %v, want %v", got, tt.want)
+				t.Errorf("parseMergeRequest() = %v, want %v", got, tt.want)
 			}
-			if got1 != tt.want1 {
-				t.Errorf("parseJSONRequest() got1 = %v, want %v", got1, tt.want1)
+		})
+	}
+}
+
+func Test_parsePullRequest(t *testing.T) {
+	body := `{
+		"action": "opened",
+		"number": 2,
+		"pull_request": {
+		  "number": 2,
+		  "state": "open",
+		  "draft": true,
+		  "head": {
+			"ref": "changes",
+			"sha": "ec26c3e57ca3a959ca5aad62de7213c562f8c821",
+			"repo": {
+			  "clone_url": "https://github.com/fork/Hello-World.git",
+			  "ssh_url": "git@github.com:fork/Hello-World.git"
 			}
-			if !reflect.DeepEqual(got2, tt.want2) {
-				t.Errorf("parseJSONRequest() got2 = %v, want %v", got2, tt.want2)
+		  },
+		  "base": {
+			"ref": "master",
+			"repo": {
+			  "clone_url": "https://github.com/Codertocat/Hello-World.git",
+			  "ssh_url": "git@github.com:Codertocat/Hello-World.git"
+			}
+		  }
+		},
+		"repository": {
+		  "clone_url": "https://github.com/Codertocat/Hello-World.git"
+		}
+	  }`
+	tests := []struct {
+		name    string
+		body    string
+		want    pullRequest
+		wantErr bool
+	}{
+		{
+			"opened_draft",
+			body,
+			pullRequest{
+				Repos:      []string{"https://github.com/Codertocat/Hello-World.git", "git@github.com:Codertocat/Hello-World.git"},
+				BaseBranch: "master",
+				HeadBranch: "changes",
+				Number:     2,
+				Action:     "opened",
+				Draft:      true,
+				SHA:        "ec26c3e57ca3a959ca5aad62de7213c562f8c821",
+			},
+			false,
+		},
+		{
+			"no_pull_request",
+			`{"action": "opened", "number": 2}`,
+			pullRequest{Repos: []string{}},
+			true,
+		},
+		{
+			"invalid_json",
+			`{`,
+			pullRequest{Repos: []string{}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "/json", strings.NewReader(tt.body))
+			got, err := parsePullRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePullRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePullRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseEventRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    hookEvent
+		wantErr bool
+	}{
+		{
+			"full",
+			`{"repo": "git://repo", "branch": "devel", "before": "a1", "after": "b2", "files": ["b", "a", "b", ""]}`,
+			hookEvent{Repo: "git://repo", Branch: "devel", Before: "a1", After: "b2", Files: []string{"a", "b"}},
+			false,
+		},
+		{
+			"default_branch",
+			`{"repo": "git://repo"}`,
+			hookEvent{Repo: "git://repo", Branch: "master", Files: []string{}},
+			false,
+		},
+		{
+			"no_repo",
+			`{"branch": "devel"}`,
+			hookEvent{Branch: "devel"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest("POST", "/event", strings.NewReader(tt.body))
+			got, err := parseEventRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseEventRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEventRequest() = %v, want %v", got, tt.want)
 			}
 		})
 	}
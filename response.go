@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// repoResult is the outcome of the matching for one repo of a request
+type repoResult struct {
+	Repo   string         `json:"repo"`
+	Branch string         `json:"branch"`
+	Jobs   []scheduledJob `json:"jobs"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// triggerResponse is the answer of the trigger endpoints
+type triggerResponse struct {
+	Repos   []repoResult `json:"repos"`
+	Skipped string       `json:"skipped,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+func newTriggerResponse() triggerResponse {
+	return triggerResponse{Repos: []repoResult{}}
+}
+
+// add records the outcome of the matching for repo and branch. A missing
+// mapping isn't reported as error but as empty list of jobs.
+func (t *triggerResponse) add(repo, branch string, jobs []scheduledJob, err error) {
+	result := repoResult{Repo: repo, Branch: branch, Jobs: jobs}
+	if result.Jobs == nil {
+		result.Jobs = []scheduledJob{}
+	}
+
+	if err != nil && err != errNoMappings {
+		result.Error = err.Error()
+	}
+
+	t.Repos = append(t.Repos, result)
+}
+
+// status returns 202 if any job was scheduled and 200 otherwise
+func (t triggerResponse) status() int {
+	for _, r := range t.Repos {
+		if len(r.Jobs) > 0 {
+			return http.StatusAccepted
+		}
+	}
+
+	return http.StatusOK
+}
+
+// writeTriggerResponse writes t with its status code
+func writeTriggerResponse(w http.ResponseWriter, t triggerResponse) {
+	writeJSON(w, t.status(), t)
+}
+
+// writeBadRequest answers a request which couldn't be parsed
+func writeBadRequest(ctx context.Context, w http.ResponseWriter, err error) {
+	loggerFrom(ctx).warn("aborting request handling", "error", err)
+
+	t := newTriggerResponse()
+	t.Error = err.Error()
+
+	writeJSON(w, http.StatusBadRequest, t)
+}
+
+// writeJSON writes v as json body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		defaultLogger.warn("writing response failed", "error", err)
+	}
+}
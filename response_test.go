@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func Test_triggerResponse_add(t *testing.T) {
+	resp := newTriggerResponse()
+	resp.add("repo1", "master", nil, errNoMappings)
+	resp.add("repo2", "master", nil, errors.New("broken"))
+
+	if status := resp.status(); status != http.StatusOK {
+		t.Errorf("triggerResponse.status() = %v, want %v", status, http.StatusOK)
+	}
+	if resp.Repos[0].Error != "" || len(resp.Repos[0].Jobs) != 0 {
+		t.Errorf("missing mapping reported as %+v", resp.Repos[0])
+	}
+	if resp.Repos[1].Error != "broken" {
+		t.Errorf("error reported as %q, want %q", resp.Repos[1].Error, "broken")
+	}
+
+	resp.add("repo3", "master", []scheduledJob{{Job: "job", Status: jobScheduled}}, nil)
+	if status := resp.status(); status != http.StatusAccepted {
+		t.Errorf("triggerResponse.status() = %v, want %v", status, http.StatusAccepted)
+	}
+}
+
+func Test_server_handlePlainGetResponse(t *testing.T) {
+	s := server{
+		mapping:        map[string][]string{"repo|master": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{QuietPeriod: 5}},
+	}
+	defer func() {
+		for _, pj := range s.timeKeeper {
+			pj.timer.Stop()
+		}
+	}()
+
+	for _, want := range []string{jobScheduled, jobReset} {
+		w := httptest.NewRecorder()
+		s.handlePlainGet().ServeHTTP(w, httptest.NewRequest("GET", "/?repo=repo&branch=master", nil))
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v", w.Code, http.StatusAccepted)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("handler returned content type %q", ct)
+		}
+
+		var resp triggerResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Repos) != 1 || len(resp.Repos[0].Jobs) != 1 {
+			t.Fatalf("handler returned %+v", resp)
+		}
+
+		job := resp.Repos[0].Jobs[0]
+		if job.Job != "job" || job.Status != want || job.FireAt.IsZero() {
+			t.Errorf("handler returned job %+v, want status %s", job, want)
+		}
+	}
+}
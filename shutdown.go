@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	pendingFire    = "fire"    // trigger pending jobs on shutdown
+	pendingPersist = "persist" // save pending jobs and restore them on start
+	pendingDrop    = "drop"    // drop pending jobs on shutdown
+)
+
+// persistedJob is a pending job saved on shutdown
+type persistedJob struct {
+	// Key is the key of the job in the time keeper, the job if empty
+	Key        string         `json:"key,omitempty"`
+	Job        string         `json:"job"`
+	FireAt     time.Time      `json:"fire_at"`
+	Params     url.Values     `json:"params,omitempty"`
+	Events     []string       `json:"events,omitempty"`
+	RequestIDs []string       `json:"request_ids,omitempty"`
+	Commits    []statusCommit `json:"commits,omitempty"`
+}
+
+// validPendingPolicy reports whether policy is a known pending job policy
+func validPendingPolicy(policy string) bool {
+	return policy == pendingFire || policy == pendingPersist || policy == pendingDrop
+}
+
+// serve serves srv until SIGINT or SIGTERM is received. Then it stops
+// accepting requests, waits for running requests to finish and applies the
+// pending job policy. With a tls config srv serves https.
+func (s *server) serve(srv *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ListenAndServeTLS("", "")
+		} else {
+			errs <- srv.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		defaultLogger.info("shutting down", "signal", sig.String())
+	}
+
+	return s.shutdown(srv)
+}
+
+// shutdown drains srv and applies the pending job policy
+func (s *server) shutdown(srv *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.param.proxy.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		defaultLogger.warn("waiting for running requests failed", "error", err)
+	}
+
+	if err := s.deliveries.save(); err != nil {
+		defaultLogger.error("saving deliveries failed", "error", err)
+	}
+
+	return s.flushPendingJobs()
+}
+
+// flushPendingJobs fires, persists or drops all pending jobs, including
+// jobs held while triggering is paused. Jobs are fired one per release
+// interval. While triggering is paused they are persisted instead.
+func (s *server) flushPendingJobs() error {
+	taken := s.takeJobs()
+
+	keys := make([]string, 0, len(taken))
+	for key := range taken {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return taken[keys[i]].fireAt.Before(taken[keys[j]].fireAt)
+	})
+
+	policy := s.param.proxy.PendingPolicy
+
+	s.timeKeeperLock.Lock()
+	paused := s.paused
+	s.timeKeeperLock.Unlock()
+
+	if policy == pendingFire && paused && len(keys) > 0 {
+		if s.param.proxy.PendingFile == "" {
+			defaultLogger.warn("triggering is paused and no pending file is set, dropping pending jobs", "jobs", len(keys))
+			policy = pendingDrop
+		} else {
+			defaultLogger.warn("triggering is paused, persisting pending jobs instead of firing them", "jobs", len(keys))
+			policy = pendingPersist
+		}
+	}
+
+	switch policy {
+	case pendingPersist:
+		return s.persistPendingJobs(keys, taken)
+	case pendingDrop:
+		for _, key := range keys {
+			loggerFrom(taken[key].context()).warn("dropping pending job on shutdown")
+		}
+	default:
+		for i, key := range keys {
+			if i > 0 {
+				time.Sleep(s.param.proxy.ReleaseInterval)
+			}
+
+			pj := taken[key]
+			ctx := pj.context()
+			loggerFrom(ctx).info("firing pending job on shutdown")
+			s.triggerJob(ctx, pj.job, pj.params)
+		}
+	}
+
+	return nil
+}
+
+// persistPendingJobs saves the pending jobs to the pending file
+func (s *server) persistPendingJobs(keys []string, taken map[string]*pendingJob) error {
+	persisted := []persistedJob{}
+	for _, key := range keys {
+		pj := taken[key]
+		persisted = append(persisted, persistedJob{
+			Key:        key,
+			Job:        pj.job,
+			FireAt:     pj.fireAt,
+			Params:     pj.params,
+			Events:     pj.events,
+			RequestIDs: pj.requestIDs,
+			Commits:    pj.commits,
+		})
+	}
+
+	data, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := s.param.proxy.PendingFile
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	defaultLogger.info("persisted pending jobs", "jobs", len(persisted), "file", path)
+
+	return nil
+}
+
+// restorePendingJobs schedules the jobs persisted on the last shutdown and
+// removes the pending file. Jobs whose fire time passed are triggered
+// right away.
+func (s *server) restorePendingJobs() error {
+	path := s.param.proxy.PendingFile
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var persisted []persistedJob
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return errors.New("reading pending jobs from " + path + ": " + err.Error())
+	}
+
+	for _, p := range persisted {
+		s.restoreJob(p)
+	}
+
+	defaultLogger.info("restored pending jobs", "jobs", len(persisted), "file", path)
+
+	return os.Remove(path)
+}
+
+// restoreJob creates the timer of a persisted job, unless the job is
+// already pending
+func (s *server) restoreJob(p persistedJob) {
+	s.timeKeeperLock.Lock()
+	defer s.timeKeeperLock.Unlock()
+
+	key := p.Key
+	if key == "" {
+		key = p.Job
+	}
+
+	if _, ok := s.timeKeeper[key]; ok {
+		return
+	}
+
+	pj := &pendingJob{
+		job:        p.Job,
+		params:     p.Params,
+		fireAt:     p.FireAt,
+		events:     p.Events,
+		requestIDs: p.RequestIDs,
+		commits:    p.Commits,
+	}
+	pj.timer = time.AfterFunc(time.Until(p.FireAt), func() {
+		s.expireTimer(key, pj)
+	})
+
+	s.timeKeeper[key] = pj
+}
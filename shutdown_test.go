@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_server_flushPendingJobs(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		wantTriggered []string
+	}{
+		{"fire", pendingFire, []string{"/job/job1/build", "/job/job2/build"}},
+		{"drop", pendingDrop, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			triggered := make(chan string, 2)
+			stub := newJenkinsStub(triggered)
+			defer stub.Close()
+
+			s := &server{
+				timeKeeper:     make(map[string]*pendingJob),
+				timeKeeperLock: new(sync.Mutex),
+				param: parameters{
+					jenkins: jenkins{URL: stub.URL},
+					proxy:   proxy{QuietPeriod: 60, PendingPolicy: tt.policy},
+				},
+			}
+			s.createTimer(context.Background(), "repo", "job1", nil, "push")
+			s.createTimer(context.Background(), "repo", "job2", nil, "push")
+
+			if err := s.flushPendingJobs(); err != nil {
+				t.Fatal(err)
+			}
+			close(triggered)
+
+			got := []string{}
+			for path := range triggered {
+				got = append(got, path)
+			}
+			if !reflect.DeepEqual(got, tt.wantTriggered) {
+				t.Errorf("triggered %v, want %v", got, tt.wantTriggered)
+			}
+			if len(s.timeKeeper) != 0 {
+				t.Errorf("%d jobs still pending", len(s.timeKeeper))
+			}
+		})
+	}
+}
+
+func Test_server_persistPendingJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pending.json")
+	params := url.Values{"MR_IID": {"1"}}
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{QuietPeriod: 60, PendingPolicy: pendingPersist, PendingFile: path}},
+	}
+	ctx := withStatusCommit(withRequestID(context.Background(), "req1"), statusCommit{Host: "git.example.com", Project: "group/project", SHA: "abc"})
+	want := s.createTimer(ctx, "repo", "job", params, "push")
+
+	if err := s.flushPendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{PendingFile: path}},
+	}
+	if err := restored.restorePendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.takeJobs()
+
+	pj, ok := restored.timeKeeper["job|repo|MR_IID=1"]
+	if !ok || pj.job != "job" {
+		t.Fatalf("job not restored: %v", restored.timeKeeper)
+	}
+	if !pj.fireAt.Equal(want.FireAt) || !reflect.DeepEqual(pj.params, params) {
+		t.Errorf("restored job fires at %s with %v, want %s with %v", pj.fireAt, pj.params, want.FireAt, params)
+	}
+	if !reflect.DeepEqual(pj.events, []string{"push"}) || !reflect.DeepEqual(pj.requestIDs, []string{"req1"}) || len(pj.commits) != 1 {
+		t.Errorf("restored job = %+v", pj)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pending file not removed: %v", err)
+	}
+}
+
+func Test_server_restorePendingJobsDue(t *testing.T) {
+	triggered := make(chan string, 1)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{jenkins: jenkins{URL: stub.URL}},
+	}
+	s.restoreJob(persistedJob{Job: "job", FireAt: time.Now().Add(-time.Minute)})
+
+	select {
+	case path := <-triggered:
+		if path != "/job/job/build" {
+			t.Errorf("triggered %s", path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("overdue job not triggered")
+	}
+}
+
+func Test_server_shutdown(t *testing.T) {
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			status <- 0
+
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-started
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{ShutdownTimeout: time.Second, PendingPolicy: pendingDrop}},
+	}
+	if err := s.shutdown(srv); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-status; got != http.StatusAccepted {
+		t.Errorf("running request answered with %d, want %d", got, http.StatusAccepted)
+	}
+}
+
+func Test_server_flushPendingJobsPaused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	triggered := make(chan string, 2)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	path := filepath.Join(dir, "pending.json")
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL},
+			proxy:   proxy{QuietPeriod: 60, PendingPolicy: pendingFire, PendingFile: path},
+		},
+	}
+	s.setPaused(true)
+	s.createTimer(context.Background(), "repo", "job1", nil, "push")
+
+	if err := s.flushPendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case path := <-triggered:
+		t.Errorf("job %s fired while triggering is paused", path)
+	default:
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("pending jobs not persisted: %v", err)
+	}
+
+	var persisted []persistedJob
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != 1 || persisted[0].Job != "job1" {
+		t.Errorf("persisted %+v, want job1", persisted)
+	}
+}
+
+func Test_server_flushPendingJobsReleaseInterval(t *testing.T) {
+	triggered := make(chan string, 3)
+	stub := newJenkinsStub(triggered)
+	defer stub.Close()
+
+	s := &server{
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param: parameters{
+			jenkins: jenkins{URL: stub.URL},
+			proxy:   proxy{QuietPeriod: 60, PendingPolicy: pendingFire, ReleaseInterval: 50 * time.Millisecond},
+		},
+	}
+	for _, job := range []string{"job1", "job2", "job3"} {
+		s.createTimer(context.Background(), "repo", job, nil, "push")
+	}
+
+	start := time.Now()
+	if err := s.flushPendingJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("three jobs fired within %v, want one per release interval", elapsed)
+	}
+	if len(triggered) != 3 {
+		t.Errorf("fired %d jobs, want 3", len(triggered))
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// createPauseSignalJob pauses triggering on SIGUSR1 and resumes it on SIGUSR2
+func (s *server) createPauseSignalJob() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			log.Print("received signal ", sig)
+
+			s.setPaused(sig == syscall.SIGUSR1)
+		}
+	}()
+}
@@ -0,0 +1,5 @@
+package main
+
+// createPauseSignalJob does nothing, windows lacks the user signals to
+// pause and resume triggering
+func (s *server) createPauseSignalJob() {}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+const defSkipMarkers = "[ci skip],[skip ci],[no ci],***NO_CI***" // default commit message markers to skip triggers
+
+// commit holds the message and the author of a pushed commit
+type commit struct {
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+}
+
+func newCommit(message, authorName, authorEmail string) commit {
+	return commit{Message: message, AuthorName: authorName, AuthorEmail: authorEmail}
+}
+
+// skipAuthor reports whether name is one of the ignored authors
+func (s *server) skipAuthor(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, author := range s.param.proxy.SkipAuthors {
+		if strings.EqualFold(author, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipCommit reports whether a commit message contains a skip marker or
+// the commit was written by an ignored author
+func (s *server) skipCommit(c commit) bool {
+	message := strings.ToLower(c.Message)
+	for _, marker := range s.param.proxy.SkipMarkers {
+		if strings.Contains(message, strings.ToLower(marker)) {
+			return true
+		}
+	}
+
+	return s.skipAuthor(c.AuthorEmail) || s.skipAuthor(c.AuthorName)
+}
+
+// skipPush reports whether a push must not trigger any job. That is the
+// case if it was pushed by an ignored user or if every commit is skipped.
+// Pushes with unknown commits are never skipped because of their commits.
+func (s *server) skipPush(ctx context.Context, pusher string, commits []commit, complete bool) bool {
+	if s.skipAuthor(pusher) {
+		loggerFrom(ctx).info("skipping push of ignored user", "pusher", pusher)
+
+		return true
+	}
+
+	if !complete || len(commits) == 0 {
+		return false
+	}
+
+	for _, c := range commits {
+		if !s.skipCommit(c) {
+			return false
+		}
+	}
+
+	loggerFrom(ctx).info("skipping push, all commits are marked to be skipped", "commits", len(commits))
+
+	return true
+}
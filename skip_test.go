@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_server_skipPush(t *testing.T) {
+	s := server{
+		param: parameters{
+			proxy: proxy{
+				SkipMarkers: splitList(defSkipMarkers),
+				SkipAuthors: []string{"bot@renovateapp.com", "release-bot"},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		pusher   string
+		commits  []commit
+		complete bool
+		want     bool
+	}{
+		{
+			"no_commits",
+			"jsmith",
+			nil,
+			true,
+			false,
+		},
+		{
+			"regular_commit",
+			"jsmith",
+			[]commit{{Message: "fix bug", AuthorEmail: "john@example.com"}},
+			true,
+			false,
+		},
+		{
+			"all_marked",
+			"jsmith",
+			[]commit{{Message: "bump version [CI SKIP]"}, {Message: "[skip ci] update changelog"}},
+			true,
+			true,
+		},
+		{
+			"partly_marked",
+			"jsmith",
+			[]commit{{Message: "bump version [ci skip]"}, {Message: "fix bug"}},
+			true,
+			false,
+		},
+		{
+			"ignored_author",
+			"jsmith",
+			[]commit{{Message: "Update dependency", AuthorName: "Renovate Bot", AuthorEmail: "bot@renovateapp.com"}},
+			true,
+			true,
+		},
+		{
+			"ignored_pusher",
+			"release-bot",
+			[]commit{{Message: "fix bug"}},
+			false,
+			true,
+		},
+		{
+			"incomplete_commits",
+			"jsmith",
+			[]commit{{Message: "bump version [ci skip]"}},
+			false,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.skipPush(context.Background(), tt.pusher, tt.commits, tt.complete); got != tt.want {
+				t.Errorf("skipPush() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	statusPending = "pending" // a job was triggered or its build started
+	statusFailure = "failure" // triggering a job failed
+
+	statusGitLab = "gitlab"
+	statusGitHub = "github"
+)
+
+// statusHost configures the commit status api of a git host
+type statusHost struct {
+	// Type is "gitlab" or "github"
+	Type  string `json:"type"`
+	Token string `json:"token"`
+	// URL is the api base url, derived from the host if empty
+	URL string `json:"url"`
+}
+
+// statusCommit is a commit of a repository on a git host
+type statusCommit struct {
+	Host    string `json:"host"`
+	Project string `json:"project"`
+	SHA     string `json:"sha"`
+}
+
+// statusReporter posts the state of triggered jobs as commit status to
+// the configured git hosts
+type statusReporter struct {
+	hosts  map[string]statusHost
+	name   string
+	client *http.Client
+}
+
+// loadStatusHosts reads the status hosts, keyed by host name, from path
+func loadStatusHosts(path string) (map[string]statusHost, error) {
+	hosts := make(map[string]statusHost)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return hosts, err
+	}
+
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return hosts, err
+	}
+
+	for name, host := range hosts {
+		if host.Type != statusGitLab && host.Type != statusGitHub {
+			return hosts, errors.New("status host " + name + ": unknown type " + host.Type)
+		}
+		if host.Token == "" {
+			return hosts, errors.New("status host " + name + ": no token defined")
+		}
+	}
+
+	return hosts, nil
+}
+
+func newStatusReporter(hosts map[string]statusHost, name string, client *http.Client) *statusReporter {
+	return &statusReporter{
+		hosts:  hosts,
+		name:   name,
+		client: client,
+	}
+}
+
+// parseRepoURL returns the host and the project path of a clone url like
+// "https://host/group/project.git" or "git@host:group/project.git"
+func parseRepoURL(repo string) (string, string, error) {
+	var host, path string
+
+	if strings.Contains(repo, "://") {
+		u, err := url.Parse(repo)
+		if err != nil {
+			return "", "", err
+		}
+		host, path = u.Hostname(), u.Path
+	} else if i := strings.Index(repo, ":"); i > 0 {
+		host, path = repo[:i], repo[i+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return "", "", errors.New("unable to parse repo url " + repo)
+	}
+
+	return host, path, nil
+}
+
+// commit returns the commit sha of repo, if the host of repo is configured
+// and sha is a valid commit
+func (r *statusReporter) commit(repo, sha string) (statusCommit, bool) {
+	if r == nil || isNullCommit(sha) || !commitPattern.MatchString(sha) {
+		return statusCommit{}, false
+	}
+
+	host, project, err := parseRepoURL(repo)
+	if err != nil {
+		return statusCommit{}, false
+	}
+
+	if _, ok := r.hosts[host]; !ok {
+		return statusCommit{}, false
+	}
+
+	return statusCommit{Host: host, Project: project, SHA: sha}, true
+}
+
+// apiURL returns the api base url of a host
+func (h statusHost) apiURL(host string) string {
+	if h.URL != "" {
+		return strings.TrimSuffix(h.URL, "/")
+	}
+
+	if h.Type == statusGitHub {
+		if host == "github.com" {
+			return "https://api.github.com"
+		}
+
+		return "https://" + host + "/api/v3"
+	}
+
+	return "https://" + host + "/api/v4"
+}
+
+// report posts state for job to all commits of ctx
+func (r *statusReporter) report(ctx context.Context, job, state, targetURL, description string) {
+	if r == nil {
+		return
+	}
+
+	l := loggerFrom(ctx)
+
+	for _, c := range statusCommitsFrom(ctx) {
+		if err := r.post(c, job, state, targetURL, description); err != nil {
+			l.warn("reporting commit status failed", "project", c.Project, "sha", c.SHA, "error", err)
+
+			continue
+		}
+
+		l.info("commit status reported", "project", c.Project, "sha", c.SHA, "state", state)
+	}
+}
+
+// post sends a single commit status to the api of the host of c
+func (r *statusReporter) post(c statusCommit, job, state, targetURL, description string) error {
+	host := r.hosts[c.Host]
+	name := r.name + "/" + job
+
+	var (
+		statusURL string
+		body      map[string]string
+	)
+
+	header := http.Header{"Content-Type": {"application/json"}}
+
+	switch host.Type {
+	case statusGitHub:
+		parts := strings.Split(c.Project, "/")
+		if len(parts) != 2 {
+			return errors.New("github project " + c.Project + " is not owner/repo")
+		}
+		statusURL = host.apiURL(c.Host) + "/repos/" + url.PathEscape(parts[0]) + "/" + url.PathEscape(parts[1]) + "/statuses/" + c.SHA
+		body = map[string]string{"state": state, "context": name}
+		header.Set("Authorization", "token "+host.Token)
+		header.Set("Accept", "application/vnd.github.v3+json")
+	default:
+		if state == statusFailure {
+			state = "failed"
+		}
+		statusURL = host.apiURL(c.Host) + "/projects/" + url.PathEscape(c.Project) + "/statuses/" + c.SHA
+		body = map[string]string{"state": state, "name": name}
+		header.Set("Private-Token", host.Token)
+	}
+
+	if targetURL != "" {
+		body["target_url"] = targetURL
+	}
+	if description != "" {
+		body["description"] = description
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", statusURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !(200 <= resp.StatusCode && resp.StatusCode <= 299) {
+		return fmt.Errorf("%s answered with status %d", statusURL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+type statusCommitKey struct{}
+
+// withStatusCommit returns a context carrying the commit whose status is
+// reported for the jobs scheduled with it
+func withStatusCommit(ctx context.Context, c statusCommit) context.Context {
+	return context.WithValue(ctx, statusCommitKey{}, c)
+}
+
+func statusCommitFrom(ctx context.Context) (statusCommit, bool) {
+	c, ok := ctx.Value(statusCommitKey{}).(statusCommit)
+
+	return c, ok
+}
+
+type statusCommitsKey struct{}
+
+// withStatusCommits returns a context carrying the commits of a pending job
+func withStatusCommits(ctx context.Context, commits []statusCommit) context.Context {
+	return context.WithValue(ctx, statusCommitsKey{}, commits)
+}
+
+func statusCommitsFrom(ctx context.Context) []statusCommit {
+	commits, _ := ctx.Value(statusCommitsKey{}).([]statusCommit)
+
+	return commits
+}
+
+// appendCommit appends the commit of ctx to commits, unless it is listed
+func appendCommit(ctx context.Context, commits []statusCommit) []statusCommit {
+	c, ok := statusCommitFrom(ctx)
+	if !ok {
+		return commits
+	}
+
+	for _, e := range commits {
+		if e == c {
+			return commits
+		}
+	}
+
+	return append(commits, c)
+}
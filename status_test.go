@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_parseRepoURL(t *testing.T) {
+	tests := []struct {
+		repo        string
+		wantHost    string
+		wantProject string
+		wantErr     bool
+	}{
+		{"https://gitlab.example.com/group/sub/project.git", "gitlab.example.com", "group/sub/project", false},
+		{"http://gitlab.example.com:8080/group/project", "gitlab.example.com", "group/project", false},
+		{"ssh://git@gitlab.example.com:2222/group/project.git", "gitlab.example.com", "group/project", false},
+		{"git@github.com:org/repo.git", "github.com", "org/repo", false},
+		{"https://gitlab.example.com/", "", "", true},
+		{"repo", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			host, project, err := parseRepoURL(tt.repo)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRepoURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if host != tt.wantHost || project != tt.wantProject {
+				t.Errorf("parseRepoURL() = %s, %s, want %s, %s", host, project, tt.wantHost, tt.wantProject)
+			}
+		})
+	}
+}
+
+// postedStatus is a commit status received by the status stub
+type postedStatus struct {
+	Path   string
+	Header http.Header
+	Body   map[string]string
+}
+
+// newStatusStub returns a git host api which records the posted statuses
+func newStatusStub() (*httptest.Server, func() []postedStatus) {
+	var (
+		mu     sync.Mutex
+		posted []postedStatus
+	)
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		mu.Lock()
+		posted = append(posted, postedStatus{Path: r.URL.EscapedPath(), Header: r.Header, Body: body})
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	return stub, func() []postedStatus {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]postedStatus{}, posted...)
+	}
+}
+
+func Test_statusReporter_report(t *testing.T) {
+	sha := "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+
+	tests := []struct {
+		name       string
+		host       statusHost
+		state      string
+		wantPath   string
+		wantHeader string
+		wantBody   map[string]string
+	}{
+		{
+			"gitlab_pending",
+			statusHost{Type: statusGitLab, Token: "secret"},
+			statusPending,
+			"/api/v4/projects/group%2Fproject/statuses/" + sha,
+			"Private-Token",
+			map[string]string{"state": "pending", "name": "trigger-proxy/job", "target_url": "http://jenkins/queue/item/1/", "description": "triggered"},
+		},
+		{
+			"gitlab_failure",
+			statusHost{Type: statusGitLab, Token: "secret"},
+			statusFailure,
+			"/api/v4/projects/group%2Fproject/statuses/" + sha,
+			"Private-Token",
+			map[string]string{"state": "failed", "name": "trigger-proxy/job", "target_url": "http://jenkins/queue/item/1/", "description": "triggered"},
+		},
+		{
+			"github_pending",
+			statusHost{Type: statusGitHub, Token: "secret"},
+			statusPending,
+			"/api/v3/repos/group/project/statuses/" + sha,
+			"Authorization",
+			map[string]string{"state": "pending", "context": "trigger-proxy/job", "target_url": "http://jenkins/queue/item/1/", "description": "triggered"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, posted := newStatusStub()
+			defer stub.Close()
+
+			tt.host.URL = stub.URL + "/api/v4"
+			if tt.host.Type == statusGitHub {
+				tt.host.URL = stub.URL + "/api/v3"
+			}
+
+			r := newStatusReporter(map[string]statusHost{"git.example.com": tt.host}, "trigger-proxy", defaultHTTPClient)
+
+			c, ok := r.commit("git@git.example.com:group/project.git", sha)
+			if !ok {
+				t.Fatal("commit of configured host not accepted")
+			}
+			if _, ok := r.commit("https://other.example.com/group/project.git", sha); ok {
+				t.Error("commit of unknown host accepted")
+			}
+			if _, ok := r.commit("git@git.example.com:group/project.git", "../../user"); ok {
+				t.Error("invalid commit accepted")
+			}
+
+			ctx := withStatusCommits(context.Background(), []statusCommit{c})
+			r.report(ctx, "job", tt.state, "http://jenkins/queue/item/1/", "triggered")
+
+			got := posted()
+			if len(got) != 1 {
+				t.Fatalf("posted %d statuses, want 1", len(got))
+			}
+			if got[0].Path != tt.wantPath {
+				t.Errorf("path = %s, want %s", got[0].Path, tt.wantPath)
+			}
+			if got[0].Header.Get(tt.wantHeader) == "" {
+				t.Errorf("header %s missing", tt.wantHeader)
+			}
+			if !reflect.DeepEqual(got[0].Body, tt.wantBody) {
+				t.Errorf("body = %v, want %v", got[0].Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_statusReporter_postGitHubNested(t *testing.T) {
+	stub, posted := newStatusStub()
+	defer stub.Close()
+
+	r := newStatusReporter(map[string]statusHost{"git.example.com": {Type: statusGitHub, URL: stub.URL}}, "trigger-proxy", defaultHTTPClient)
+
+	c, ok := r.commit("https://git.example.com/group/sub/project.git", "da1560886d4f094c3e6c9ef40349f7d38b5d27d7")
+	if !ok {
+		t.Fatal("commit of configured host not accepted")
+	}
+	if err := r.post(c, "job", statusPending, "", ""); err == nil {
+		t.Error("post() of nested github project succeeded")
+	}
+	if got := posted(); len(got) != 0 {
+		t.Errorf("posted %d statuses, want 0", len(got))
+	}
+}
+
+func Test_server_reportStatus(t *testing.T) {
+	sha := "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+	repo := "git@git.example.com:group/project.git"
+
+	status, posted := newStatusStub()
+	defer status.Close()
+
+	stub := newMockJenkins("job", false)
+	defer stub.Close()
+
+	s := &server{
+		mapping:        mapping{buildMappingKey([]string{repo, "master"}): {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		builds:         newBuildTracker(),
+		status:         newStatusReporter(map[string]statusHost{"git.example.com": {Type: statusGitLab, Token: "secret", URL: status.URL}}, "ci", defaultHTTPClient),
+		param: parameters{
+			jenkins: jenkins{
+				URL:          stub.URL,
+				Token:        "token",
+				FollowQueue:  true,
+				PollInterval: 10 * time.Millisecond,
+				QueueTimeout: 2 * time.Second,
+			},
+			proxy: proxy{QuietPeriod: 0},
+		},
+	}
+
+	ctx := withAuditEvent(context.Background(), auditEvent{Provider: "gitlab", After: sha})
+	if _, err := s.processMatching(ctx, repo, "master", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(posted()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got := posted()
+	if len(got) != 2 {
+		t.Fatalf("posted %d statuses, want 2", len(got))
+	}
+	if got[0].Body["target_url"] != stub.URL+"/queue/item/1/" || got[0].Body["name"] != "ci/job" {
+		t.Errorf("status of the trigger = %v", got[0].Body)
+	}
+	if got[1].Body["target_url"] != stub.URL+"/job/job/5/" || got[1].Body["state"] != "pending" {
+		t.Errorf("status of the started build = %v", got[1].Body)
+	}
+}
@@ -1,34 +1,179 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/url"
+	"strings"
 	"time"
 )
 
-func (s *server) createTimer(job string) {
-	if _, ok := s.timeKeeper[job]; ok {
-		log.Print("reseting timer for job ", job)
-		s.timeKeeper[job].Stop()
-		delete(s.timeKeeper, job)
-	}
+const (
+	jobScheduled = "scheduled" // the job had no pending timer
+	jobReset     = "reset"     // the pending timer of the job was reset
+)
 
-	log.Printf("creating timer for job '%s' with quiet period of %d seconds", job, s.param.proxy.QuietPeriod)
+// changeParams are the parameters identifying the change a job is
+// triggered for. The job is pending once per change, so a merge request
+// doesn't replace the build of another one.
+var changeParams = []string{"MR_IID", "PR_NUMBER", "GERRIT_CHANGE_NUMBER"}
 
-	timer := time.AfterFunc(time.Second*time.Duration(s.param.proxy.QuietPeriod), func() {
-		log.Print("quiet period exceeded for job ", job)
-		s.triggerJob(job)
-		if _, ok := s.timeKeeper[job]; ok {
-			log.Print("deleting timer for job ", job)
-			delete(s.timeKeeper, job)
+// pendingKey returns the key of job in the time keeper, the job itself or
+// the job, the project of repo and the change of params. Changes are only
+// numbered uniquely within a project.
+func pendingKey(job, repo string, params url.Values) string {
+	for _, p := range changeParams {
+		if v := params.Get(p); v != "" {
+			return buildMappingKey([]string{job, repoProject(repo), p + "=" + v})
 		}
+	}
+
+	return job
+}
+
+// repoProject returns host and path of repo, so the http and ssh url of a
+// project name the same project
+func repoProject(repo string) string {
+	host, path, err := parseRepoURL(repo)
+	if err != nil {
+		return repo
+	}
+
+	return host + "/" + path
+}
+
+// pendingJob is a job waiting for its quiet period to pass
+type pendingJob struct {
+	job    string
+	timer  *time.Timer
+	params url.Values
+	fireAt time.Time
+	// events lists the events which scheduled or reset the timer
+	events []string
+	// requestIDs lists the requests which scheduled or reset the timer
+	requestIDs []string
+	// commits lists the commits whose status is reported for the job
+	commits []statusCommit
+	// held is set if the quiet period passed while triggering was paused
+	held bool
+}
+
+// context returns a context whose logger names the job and the requests
+// which scheduled it
+func (pj *pendingJob) context() context.Context {
+	l := defaultLogger.with("job", pj.job, "request_ids", strings.Join(pj.requestIDs, ","))
+
+	ctx := withRequestIDs(withLogger(context.Background(), l), pj.requestIDs)
+
+	return withStatusCommits(ctx, pj.commits)
+}
+
+// scheduledJob reports the timer createTimer set for a job
+type scheduledJob struct {
+	Job    string    `json:"job"`
+	Status string    `json:"status"`
+	FireAt time.Time `json:"fire_at"`
+}
+
+// createTimer schedules job for an event of repo, resetting the timer of
+// the job if it is pending already
+func (s *server) createTimer(ctx context.Context, repo, job string, params url.Values, event string) scheduledJob {
+	s.timeKeeperLock.Lock()
+	defer s.timeKeeperLock.Unlock()
+
+	key := pendingKey(job, repo, params)
+	l := loggerFrom(ctx).with("job", job)
+
+	status := jobScheduled
+	var events, requestIDs []string
+	var commits []statusCommit
+	if old, ok := s.timeKeeper[key]; ok {
+		l.info("resetting timer")
+		old.timer.Stop()
+		delete(s.timeKeeper, key)
+		events = old.events
+		requestIDs = old.requestIDs
+		commits = old.commits
+		status = jobReset
+		metrics.inc(metricTimerResets)
+	}
+
+	quietPeriod := time.Second * time.Duration(s.param.proxy.QuietPeriod)
+	pj := &pendingJob{
+		job:        job,
+		params:     params,
+		fireAt:     time.Now().Add(quietPeriod),
+		events:     appendUnique(events, event),
+		requestIDs: appendUnique(requestIDs, requestIDFrom(ctx)),
+		commits:    appendCommit(ctx, commits),
+	}
+	pj.timer = time.AfterFunc(quietPeriod, func() {
+		s.expireTimer(key, pj)
 	})
 
-	s.timeKeeper[job] = timer
-	if _, ok := s.timeKeeper[job]; ok {
-		log.Print("timer saved in time keeper")
+	s.timeKeeper[key] = pj
+	l.info("timer created", "quiet_period", s.param.proxy.QuietPeriod, "fire_at", pj.fireAt.Format(time.RFC3339))
+
+	return scheduledJob{Job: job, Status: status, FireAt: pj.fireAt}
+}
+
+// expireTimer triggers the job pending at key after its quiet period,
+// unless the timer was replaced in the meantime. While triggering is paused
+// the job is held.
+func (s *server) expireTimer(key string, pj *pendingJob) {
+	s.timeKeeperLock.Lock()
+	if s.timeKeeper[key] != pj {
+		s.timeKeeperLock.Unlock()
+
+		return
+	}
+
+	ctx := pj.context()
+	l := loggerFrom(ctx)
+
+	if s.paused {
+		l.info("quiet period exceeded while triggering is paused, holding job")
+		pj.held = true
+		s.timeKeeperLock.Unlock()
+
+		return
+	}
+
+	l.debug("quiet period exceeded, deleting timer")
+	delete(s.timeKeeper, key)
+	s.timeKeeperLock.Unlock()
+
+	s.triggerJob(ctx, pj.job, pj.params)
+}
+
+// appendUnique appends v to list, unless it is empty or already listed
+func appendUnique(list []string, v string) []string {
+	if v == "" {
+		return list
+	}
+
+	for _, e := range list {
+		if e == v {
+			return list
+		}
+	}
+
+	return append(list, v)
+}
+
+// isPending reports whether job waits for its quiet period to pass, for
+// any change
+func (s *server) isPending(job string) bool {
+	s.timeKeeperLock.Lock()
+	defer s.timeKeeperLock.Unlock()
+
+	for _, pj := range s.timeKeeper {
+		if pj.job == job {
+			return true
+		}
 	}
 
-	return
+	return false
 }
 
 func (s *server) createRefreshJob() {
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -18,8 +24,10 @@ func Test_server_createTimer(t *testing.T) {
 		{
 			"simple",
 			server{
-				mapping:    map[string][]string{"git://repo/repo|branch": {"job"}},
-				timeKeeper: map[string]*time.Timer{"job": time.AfterFunc(time.Second*time.Duration(1), func() {})},
+				mapping:        map[string][]string{"git://repo/repo|branch": {"job"}},
+				timeKeeper:     map[string]*pendingJob{"job": {timer: time.AfterFunc(time.Second*time.Duration(1), func() {})}},
+				timeKeeperLock: new(sync.Mutex),
+				param:          parameters{proxy: proxy{QuietPeriod: 60}},
 			},
 			args{job: "job"},
 			1,
@@ -27,11 +35,78 @@ func Test_server_createTimer(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.s.createTimer(tt.args.job)
+			tt.s.createTimer(context.Background(), "repo", tt.args.job, nil, "")
+			defer tt.s.takeJobs()
+
+			tt.s.timeKeeperLock.Lock()
 			got := len(tt.s.timeKeeper)
+			tt.s.timeKeeperLock.Unlock()
 			if got != tt.want {
 				t.Errorf("server_createTimer() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_server_handleMergeRequestsSameTarget(t *testing.T) {
+	s := server{
+		mapping:        map[string][]string{"merge_request|git@repo:magic/repo.git|master|*": {"job"}},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{QuietPeriod: 60, MRActions: []string{"open"}}},
+	}
+	defer s.takeJobs()
+
+	for _, mr := range []struct{ iid, source string }{{"1", "feature-a"}, {"2", "feature-b"}, {"1", "feature-a"}} {
+		body := `{"object_kind": "merge_request", "object_attributes": {"iid": ` + mr.iid + `, "action": "open", "source_branch": "` + mr.source + `", "target_branch": "master", "target": {"git_ssh_url": "git@repo:magic/repo.git"}}}`
+		r := httptest.NewRequest("POST", "/json", strings.NewReader(body))
+		r.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+		http.HandlerFunc(s.handleJSONPost()).ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	jobs := s.pendingJobs().Jobs
+	if len(jobs) != 2 {
+		t.Fatalf("merge requests scheduled %d pending jobs, want 2: %+v", len(jobs), jobs)
+	}
+
+	sources := map[string]string{}
+	for _, pj := range jobs {
+		if pj.Job != "job" {
+			t.Errorf("pending job %s triggers %s, want job", pj.Key, pj.Job)
+		}
+		sources[pj.Params.Get("MR_IID")] = pj.Params.Get("MR_SOURCE_BRANCH")
+	}
+	if sources["1"] != "feature-a" || sources["2"] != "feature-b" {
+		t.Errorf("pending merge requests = %v", sources)
+	}
+}
+
+func Test_server_handleMergeRequestsSameIID(t *testing.T) {
+	s := server{
+		mapping: map[string][]string{
+			"merge_request|git://repo/a|master|*": {"verify"},
+			"merge_request|git://repo/b|master|*": {"verify"},
+		},
+		timeKeeper:     make(map[string]*pendingJob),
+		timeKeeperLock: new(sync.Mutex),
+		param:          parameters{proxy: proxy{QuietPeriod: 60, MRActions: []string{"open"}}},
+	}
+	defer s.takeJobs()
+
+	for _, mr := range []struct{ repo, source string }{{"git://repo/a", "fa"}, {"git://repo/b", "fb"}} {
+		body := `{"object_kind": "merge_request", "object_attributes": {"iid": 7, "action": "open", "source_branch": "` + mr.source + `", "target_branch": "master", "target": {"git_http_url": "` + mr.repo + `"}}}`
+		r := httptest.NewRequest("POST", "/json", strings.NewReader(body))
+		r.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+		http.HandlerFunc(s.handleJSONPost()).ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	sources := map[string]string{}
+	for _, pj := range s.pendingJobs().Jobs {
+		sources[pj.Key] = pj.Params.Get("MR_SOURCE_BRANCH")
+	}
+
+	want := map[string]string{"verify|repo/a|MR_IID=7": "fa", "verify|repo/b|MR_IID=7": "fb"}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("pending merge requests = %v, want %v", sources, want)
+	}
+}
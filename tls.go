@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const certCheckInterval = 10 * time.Second // interval to check the certificate files for changes
+
+// certReloader serves the certificate of a cert and key file and reloads it
+// when the files change
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval}
+
+	modTime, err := c.modified()
+	if err != nil {
+		return c, err
+	}
+
+	if err := c.load(modTime); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// modified returns the time the cert or the key file was last modified
+func (c *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// load reads the key pair modified at modTime
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+
+	return nil
+}
+
+// getCertificate returns the current certificate. Changed files are loaded
+// at most once per check interval. If they can't be loaded, e.g. because
+// only the certificate was replaced yet, the previous certificate is kept.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < c.interval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+
+	modTime, err := c.modified()
+	if err != nil {
+		defaultLogger.warn("checking certificate files failed", "error", err)
+
+		return c.cert, nil
+	}
+
+	if modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	if err := c.load(modTime); err != nil {
+		defaultLogger.warn("reloading certificate failed, keeping the previous one", "error", err)
+
+		return c.cert, nil
+	}
+
+	defaultLogger.info("reloaded certificate", "cert", c.certFile)
+
+	return c.cert, nil
+}
+
+// loadCertPool reads a bundle of pem encoded CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + path)
+	}
+
+	return pool, nil
+}
+
+// tlsConfig returns the tls configuration of the server, or nil if the
+// proxy serves plain http
+func (s *server) tlsConfig() (*tls.Config, error) {
+	p := s.param.proxy
+
+	if p.TLSCert == "" && p.TLSKey == "" {
+		if p.TLSClientCA != "" {
+			return nil, errors.New("tls-client-ca needs tls-cert and tls-key")
+		}
+
+		return nil, nil
+	}
+
+	if p.TLSCert == "" || p.TLSKey == "" {
+		return nil, errors.New("tls-cert and tls-key have to be set both")
+	}
+
+	reloader, err := newCertReloader(p.TLSCert, p.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+
+	if p.TLSClientCA != "" {
+		pool, err := loadCertPool(p.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		// certificates are verified if sent, requireClientCert rejects
+		// requests without one except for the health endpoints
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+// clientCertExempt are the paths served without client certificate, so
+// probes of the orchestration are able to reach them
+var clientCertExempt = map[string]bool{
+	"/livez":  true,
+	"/readyz": true,
+}
+
+// requireClientCert rejects requests without a verified client certificate
+func requireClientCert(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !clientCertExempt[r.URL.Path] && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			loggerFrom(r.Context()).warn("rejecting request without client certificate", "remote_addr", r.RemoteAddr)
+
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a pem encoded certificate and key for name signed by ca
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_certReloader_getCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ca := newTestCA(t, "ca")
+
+	cert, key := ca.issue(t, "first", x509.ExtKeyUsageServerAuth)
+	modTime := time.Now().Add(-time.Minute)
+	writeTestFile(t, certFile, cert, modTime)
+	writeTestFile(t, keyFile, key, modTime)
+
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.interval = 0
+
+	commonName := func() string {
+		got, err := c.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(got.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return leaf.Subject.CommonName
+	}
+
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate %s, want first", got)
+	}
+
+	// a certificate without its key is not loaded
+	cert, key = ca.issue(t, "second", x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, certFile, cert, modTime.Add(time.Second))
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate %s after replacing the certificate only, want first", got)
+	}
+
+	writeTestFile(t, keyFile, key, modTime.Add(2*time.Second))
+	if got := commonName(); got != "second" {
+		t.Errorf("certificate %s after replacing the key, want second", got)
+	}
+}
+
+func Test_server_tlsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       proxy
+		wantNil bool
+		wantErr bool
+	}{
+		{"plain", proxy{}, true, false},
+		{"cert_without_key", proxy{TLSCert: "cert.pem"}, true, true},
+		{"ca_without_cert", proxy{TLSClientCA: "ca.pem"}, true, true},
+		{"missing_files", proxy{TLSCert: "missing.pem", TLSKey: "missing.pem"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{param: parameters{proxy: tt.p}}
+			got, err := s.tlsConfig()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tlsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("tlsConfig() = %v", got)
+			}
+		})
+	}
+}
+
+func Test_requireClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	cert, key := ca.issue(t, "proxy", x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, filepath.Join(dir, "cert.pem"), cert, time.Now())
+	writeTestFile(t, filepath.Join(dir, "key.pem"), key, time.Now())
+	writeTestFile(t, filepath.Join(dir, "ca.pem"), ca.pem, time.Now())
+
+	s := &server{param: parameters{proxy: proxy{
+		TLSCert:     filepath.Join(dir, "cert.pem"),
+		TLSKey:      filepath.Join(dir, "key.pem"),
+		TLSClientCA: filepath.Join(dir, "ca.pem"),
+	}}}
+	config, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:   requireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+		TLSConfig: config,
+	}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	clientCert, clientKey := ca.issue(t, "scm", x509.ExtKeyUsageClientAuth)
+	trusted, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestCA(t, "other")
+	otherCert, otherKey := other.issue(t, "scm", x509.ExtKeyUsageClientAuth)
+	untrusted, err := tls.X509KeyPair(otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name       string
+		certs      []tls.Certificate
+		path       string
+		wantStatus int
+	}{
+		{"trusted", []tls.Certificate{trusted}, "/json", http.StatusOK},
+		{"no_cert", nil, "/json", http.StatusForbidden},
+		{"no_cert_readyz", nil, "/readyz", http.StatusOK},
+		// the client only sends certificates of the CAs the server accepts
+		{"untrusted", []tls.Certificate{untrusted}, "/json", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: tt.certs},
+			}}
+
+			resp, err := client.Get("https://" + ln.Addr().String() + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -1,21 +1,51 @@
 package main
 
 import (
-	"crypto/tls"
-	"log"
+	"context"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
-func (s *server) triggerJob(job string) bool {
-	url := createJobURL(s.param.jenkins.URL, job)
+func (s *server) triggerJob(ctx context.Context, job string, params url.Values) bool {
+	l := loggerFrom(ctx)
+
+	jobURL := createJobURL(s.param.jenkins.URL, job)
+
+	query := url.Values{}
+	if len(params) > 0 {
+		jobURL = createParameterizedJobURL(s.param.jenkins.URL, job)
+
+		for key, values := range params {
+			query[key] = values
+		}
+	}
+
+	rec := auditRecord{
+		Type:       auditTriggerRecord,
+		RequestIDs: requestIDsFrom(ctx),
+		Job:        job,
+		Jenkins:    &auditResponse{URL: jobURL, Params: params},
+	}
+	defer func() {
+		rec.Time = time.Now()
+		s.audit.write(rec)
+	}()
 
 	if s.param.jenkins.User == "" {
-		url = string(url + "?token=" + s.param.jenkins.Token)
+		query.Set("token", s.param.jenkins.Token)
 	}
 
-	req, err := http.NewRequest("POST", url, nil)
+	if len(query) > 0 {
+		jobURL = jobURL + "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("POST", jobURL, nil)
 	if err != nil {
+		l.error("creating trigger request failed", "error", err)
+		rec.Jenkins.Error = err.Error()
+
 		return false
 	}
 
@@ -24,24 +54,38 @@ func (s *server) triggerJob(job string) bool {
 		req.SetBasicAuth(s.param.jenkins.User, s.param.jenkins.Token)
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
+	start := time.Now()
 
-	timeout := time.Duration(5 * time.Second)
-	client := &http.Client{Transport: tr, Timeout: timeout}
-	resp, err := client.Do(req)
+	resp, err := s.jenkinsClient().Do(req)
 
 	if err != nil {
-		log.Print("Error:", err)
+		l.error("trigger failed", "error", err)
+		observeTrigger(job, "error", start)
+		rec.Jenkins.Error = err.Error()
+		s.status.report(ctx, job, statusFailure, "", "Triggering the Jenkins job failed")
 
 		return false
 	}
+	defer resp.Body.Close()
+
+	rec.Jenkins.Status = resp.StatusCode
+	rec.Jenkins.QueueItem = resp.Header.Get("Location")
 
 	if !(200 <= resp.StatusCode && resp.StatusCode <= 299) {
-		log.Printf("... %v trigger failed with status code %v\n", job, resp.StatusCode)
+		l.error("trigger failed", "status", resp.StatusCode)
+		observeTrigger(job, "failure", start)
+		s.status.report(ctx, job, statusFailure, "", "Jenkins rejected the trigger with status "+strconv.Itoa(resp.StatusCode))
 	} else {
-		log.Printf("... %v triggered\n", job)
+		l.info("job triggered", "status", resp.StatusCode, "queue_item", rec.Jenkins.QueueItem)
+		observeTrigger(job, "success", start)
+
+		target := rec.Jenkins.QueueItem
+		if target == "" {
+			target = createJobPageURL(s.param.jenkins.URL, job)
+		}
+		s.status.report(ctx, job, statusPending, target, "Jenkins job triggered")
+
+		s.trackBuild(ctx, job, rec.Jenkins.QueueItem)
 	}
 
 	return true